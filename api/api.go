@@ -0,0 +1,168 @@
+// Package api defines the JSON types exchanged between the gowork server and
+// its clients.
+package api
+
+import "time"
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+    Error string `json:"error"` // Human-readable description.
+    Code  string `json:"code"`  // Machine-readable reason, one of the Code constants.
+}
+
+// Error codes reported in ErrorResponse.Code.
+const (
+    CodeBadRequest        = "bad_request"
+    CodeUnauthorized      = "unauthorized"
+    CodeForbidden         = "forbidden"
+    CodeNotFound          = "not_found"
+    CodeMethodNotAllowed  = "method_not_allowed"
+    CodeAlreadyRunning    = "already_running"
+    CodeNotRunning        = "not_running"
+    CodeNotStarted        = "not_started" // The process has never been started; a not_running error.
+    CodeStdinClosed       = "stdin_closed"
+    CodeRestartInProgress = "restart_in_progress"
+    CodeStartCancelled    = "start_cancelled"
+    CodeRateLimited       = "rate_limited"
+    CodeTimeout           = "timeout"
+    CodeInternal          = "internal_error"
+)
+
+// ProcessStatus defines the possible states of the managed process.
+type ProcessStatus string
+
+const (
+    StatusNotStarted   ProcessStatus = "not_started"
+    StatusStarting     ProcessStatus = "starting" // Running its pre-start hook.
+    StatusRunning      ProcessStatus = "running"
+    StatusDraining     ProcessStatus = "draining" // Running, but asked to finish current work and take no more.
+    StatusSuccess      ProcessStatus = "success"
+    StatusFailed       ProcessStatus = "failed"
+    StatusTimedOut     ProcessStatus = "timed_out"
+    StatusUnhealthy    ProcessStatus = "unhealthy" // Stopped for failing its health check, or not passing one within the start timeout.
+    StatusStopped      ProcessStatus = "stopped"
+    StatusBackoff      ProcessStatus = "backoff"       // Waiting to be restarted after a failure.
+    StatusCrashLooping ProcessStatus = "crash_looping" // Exited quickly too often; not restarted until started manually.
+)
+
+// TerminationReason tells how the last run of a process ended.
+type TerminationReason string
+
+const (
+    TerminationExited   TerminationReason = "exited"   // Exited on its own.
+    TerminationSignaled TerminationReason = "signaled" // Killed by a signal the manager did not send.
+    TerminationStopped  TerminationReason = "stopped"  // Exited after the manager sent the stop signal.
+    TerminationKilled   TerminationReason = "killed"   // Killed with SIGKILL by the manager.
+)
+
+// StatusReport is the JSON representation of a process served by /status.
+type StatusReport struct {
+    Labels             map[string]string `json:"labels,omitempty"` // Metadata given with -label or in the definition.
+    Status             ProcessStatus     `json:"status"`
+    PID                int               `json:"pid,omitempty"`  // Set while the process is running.
+    PPID               int               `json:"ppid,omitempty"` // Parent of the process; the manager unless re-attached.
+    PreviousPID        int               `json:"previous_pid,omitempty"` // During a blue/green restart, the old instance that runs until PID is healthy.
+    Executable         string            `json:"executable"`     // Resolved absolute path of the binary.
+    Args               []string          `json:"args"`           // Of the current run while running, else those of the next start.
+    ExitCode           *int              `json:"exit_code,omitempty"`
+    TerminationReason  TerminationReason `json:"termination_reason,omitempty"` // How the last run ended; set once it has exited.
+    StartedAt          *time.Time        `json:"started_at,omitempty"`
+    UptimeSeconds      *float64          `json:"uptime_seconds,omitempty"`       // Set while the process is running.
+    RunDurationSeconds *float64          `json:"run_duration_seconds,omitempty"` // Set once the process has exited.
+    IdleSeconds        *float64          `json:"idle_seconds,omitempty"`         // Time since the running process last wrote output.
+    Stats              *ProcessStats     `json:"stats,omitempty"`                // Latest /stats sample of the current run.
+    LastError          string            `json:"last_error,omitempty"`           // Why the most recent start or run failed.
+    StderrLines        int               `json:"stderr_lines"`                   // Lines written to stderr by the current or last run.
+    CrashLooping       bool              `json:"crash_looping,omitempty"`        // Automatic restarts were given up on.
+    Health             *HealthReport     `json:"health,omitempty"`               // Latest health probe of the current run.
+    LogTail            []string          `json:"log_tail,omitempty"`             // Last lines of output, if requested with ?logtail=N.
+}
+
+// HealthReport is the result of the most recent health probe of a process.
+type HealthReport struct {
+    Healthy             bool      `json:"healthy"`
+    CheckedAt           time.Time `json:"checked_at"`
+    ConsecutiveFailures int       `json:"consecutive_failures"`
+    StatusCode          int       `json:"status_code,omitempty"` // Response status of an HTTP probe.
+    Output              string    `json:"output,omitempty"` // Tail of the probe output, or why it failed.
+}
+
+// ProcessStats is a sample of the resource usage of a running process.
+type ProcessStats struct {
+    PID              int       `json:"pid"`
+    RSSBytes         int64     `json:"rss_bytes"`
+    CPUUserSeconds   float64   `json:"cpu_user_seconds"`
+    CPUSystemSeconds float64   `json:"cpu_system_seconds"`
+    SampledAt        time.Time `json:"sampled_at"`
+}
+
+// ProcessEvent is one entry of the run history served by /history.
+type ProcessEvent struct {
+    PID       int               `json:"pid,omitempty"` // 0 if the process could not be started.
+    StartedAt time.Time         `json:"started_at"`
+    ExitedAt  *time.Time        `json:"exited_at,omitempty"`
+    ExitCode  *int              `json:"exit_code,omitempty"`
+    Status    ProcessStatus     `json:"status"`                       // How the run ended, or running.
+    Error     string            `json:"error,omitempty"`              // Why the run failed, as in StatusReport.LastError.
+    Reason    TerminationReason `json:"termination_reason,omitempty"` // How the run ended, once it has exited.
+}
+
+// ReplicaSummary is the combined status of the replicas of a worker, served
+// by /status when the manager runs more than one.
+type ReplicaSummary struct {
+    Name     string                `json:"name"`
+    Replicas int                   `json:"replicas"`
+    Running  int                   `json:"running"`
+    Statuses map[ProcessStatus]int `json:"statuses"` // Number of replicas in each status.
+    Members  []ProcessInfo         `json:"members"`
+}
+
+// ConfigUpdate is the body accepted by /config.
+type ConfigUpdate struct {
+    Args  *[]string `json:"args"`  // Required; replaces the process arguments, one argv entry per element.
+    Force bool      `json:"force"` // Restart a running process to apply the update.
+}
+
+// StatusTransition is posted to the webhook whenever the status of a
+// process changes.
+type StatusTransition struct {
+    Process   string        `json:"process"`
+    OldStatus ProcessStatus `json:"old_status"`
+    NewStatus ProcessStatus `json:"new_status"`
+    ExitCode  *int          `json:"exit_code"` // Of the last run; null until the process has exited.
+    Timestamp time.Time     `json:"timestamp"`
+}
+
+// ArgAppend is the body accepted by /config/args/append.
+type ArgAppend struct {
+    Arg   *string `json:"arg"`   // Required; appended to the process arguments.
+    Force bool    `json:"force"` // Restart a running process to apply the update.
+}
+
+// ExitReport is the response of /exit, sent once the processes have been
+// stopped and just before the manager exits.
+type ExitReport struct {
+    Stopped   []string `json:"stopped"`   // Processes confirmed not to be running.
+    Unstopped []string `json:"unstopped"` // Processes still running when the wait for them timed out.
+}
+
+// VersionInfo identifies the build of a gowork binary, served by /version.
+type VersionInfo struct {
+    Version   string `json:"version"`
+    Commit    string `json:"commit,omitempty"`
+    BuildDate string `json:"build_date,omitempty"`
+    GoVersion string `json:"go_version,omitempty"`
+}
+
+// Ownership is the answer of /owns about one PID.
+type Ownership struct {
+    PID   int  `json:"pid"`
+    Owned bool `json:"owned"` // The PID is that of the running process, or of the one a blue/green restart is replacing.
+    Alive bool `json:"alive"` // Some live process has the PID, owned or not.
+}
+
+// ProcessInfo is an entry of the list served by /processes.
+type ProcessInfo struct {
+    ID     string        `json:"id"`
+    Status ProcessStatus `json:"status"`
+}
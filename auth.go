@@ -0,0 +1,104 @@
+package main
+
+import (
+    "crypto/subtle"
+    "fmt"
+    "log"
+    "net/http"
+    "net/netip"
+    "strings"
+
+    "gowork/api"
+)
+
+// requireToken rejects requests whose Authorization header does not carry
+// the expected bearer token with 401 Unauthorized.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+            log.Printf("API: %s rejected: missing or invalid bearer token", r.URL.Path)
+            w.Header().Set("WWW-Authenticate", `Bearer realm="gowork"`)
+            writeError(w, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+            return
+        }
+        next(w, r)
+    }
+}
+
+// parsePrefixes parses IP addresses and CIDR ranges such as "10.0.0.0/8".
+// A bare address matches only itself.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+    prefixes := make([]netip.Prefix, 0, len(values))
+    for _, v := range values {
+        if strings.Contains(v, "/") {
+            p, err := netip.ParsePrefix(v)
+            if err != nil {
+                return nil, fmt.Errorf("invalid CIDR %q: %w", v, err)
+            }
+            prefixes = append(prefixes, p.Masked())
+            continue
+        }
+        addr, err := netip.ParseAddr(v)
+        if err != nil {
+            return nil, fmt.Errorf("invalid IP address %q: %w", v, err)
+        }
+        prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+    }
+    return prefixes, nil
+}
+
+// containsAddr reports whether addr falls within any of prefixes.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+    for _, p := range prefixes {
+        if p.Contains(addr) {
+            return true
+        }
+    }
+    return false
+}
+
+// clientAddr returns the address of the client that made r. Requests from a
+// trusted proxy are attributed to the last address in X-Forwarded-For that
+// is not itself a trusted proxy, since earlier entries can be forged by the
+// client.
+func clientAddr(r *http.Request, trustedProxies []netip.Prefix) (netip.Addr, error) {
+    ap, err := netip.ParseAddrPort(r.RemoteAddr)
+    if err != nil {
+        return netip.Addr{}, err
+    }
+    addr := ap.Addr().Unmap()
+    if !containsAddr(trustedProxies, addr) {
+        return addr, nil
+    }
+
+    var hops []string
+    for _, header := range r.Header.Values("X-Forwarded-For") {
+        hops = append(hops, strings.Split(header, ",")...)
+    }
+    for i := len(hops) - 1; i >= 0; i-- {
+        hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+        if err != nil {
+            return netip.Addr{}, fmt.Errorf("invalid X-Forwarded-For entry %q", hops[i])
+        }
+        addr = hop.Unmap()
+        if !containsAddr(trustedProxies, addr) {
+            break
+        }
+    }
+    return addr, nil
+}
+
+// requireAllowedIP rejects requests from clients outside allowed with 403
+// Forbidden.
+func requireAllowedIP(allowed, trustedProxies []netip.Prefix, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        addr, err := clientAddr(r, trustedProxies)
+        if err != nil || !containsAddr(allowed, addr) {
+            log.Printf("API: %s rejected: client %s is not allowed", r.URL.Path, r.RemoteAddr)
+            writeError(w, http.StatusForbidden, api.CodeForbidden, "Forbidden")
+            return
+        }
+        next(w, r)
+    }
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os/exec"
+    "syscall"
+    "time"
+
+    "gowork/api"
+)
+
+// blueGreenReadyTimeout bounds how long a blue/green restart waits for the
+// new instance to pass a health check when no start timeout is configured.
+const blueGreenReadyTimeout = time.Minute
+
+// blueGreenPollInterval is how often a blue/green restart checks whether the
+// new instance has passed a health check.
+const blueGreenPollInterval = 100 * time.Millisecond
+
+// retiringRun is the run a blue/green restart replaces. It keeps running,
+// outside pm.process and pm.done, until its successor is ready, and is put
+// back in place if the successor fails.
+type retiringRun struct {
+    cmd          *exec.Cmd
+    ppid         int
+    stdin        io.WriteCloser
+    spool        string
+    runArgs      []string
+    startedAt    time.Time
+    health       *HealthReport
+    runtimeTimer *time.Timer
+    done         chan struct{}
+    stopping     bool // Set once the run has been signalled to stop.
+    killed       bool // Set once the run has been sent SIGKILL.
+}
+
+// RestartBlueGreen replaces the running process without a gap: a new
+// instance is started next to it, and only once that passes a health check
+// is the old one stopped, escalating to SIGKILL after stopTimeout (the kill
+// timeout if not positive). If the new instance fails to start, exits or
+// does not become healthy in time, it is stopped and the old one carries on.
+//
+// Both instances run at once, so a child serving a port must be able to bind
+// it while the old one still holds it, for example with SO_REUSEPORT; the
+// manager does not hand over listeners. A health check is required, and the
+// process must have been started by this manager.
+func (pm *ProcessManager) RestartBlueGreen(ctx context.Context, stopTimeout time.Duration) error {
+    if stopTimeout <= 0 {
+        stopTimeout = pm.killTimeout
+    }
+
+    pm.mu.Lock()
+    switch {
+    case !pm.healthCheck.enabled():
+        pm.mu.Unlock()
+        return errors.New("blue/green restart requires a health check")
+    case pm.retiring != nil:
+        pm.mu.Unlock()
+        return ErrRestartInProgress
+    case pm.status != StatusRunning:
+        pm.mu.Unlock()
+        return pm.notRunningLocked()
+    case pm.cmd == nil:
+        // A re-attached process is not our child; its exit cannot be
+        // told apart from that of its successor.
+        pm.mu.Unlock()
+        return errors.New("blue/green restart is not supported for a re-attached process")
+    }
+    old := &retiringRun{
+        cmd:          pm.cmd,
+        ppid:         pm.ppid,
+        stdin:        pm.stdin,
+        spool:        pm.spool,
+        runArgs:      pm.runArgs,
+        startedAt:    pm.startedAt,
+        health:       pm.health,
+        runtimeTimer: pm.runtimeTimer,
+        done:         pm.done,
+    }
+    pm.retiring = old
+    pm.runtimeTimer = nil
+    log.Printf("Blue/green restart of %q: starting a new instance next to PID %d.", pm.name, old.cmd.Process.Pid)
+    if err := pm.startLocked(ctx); err != nil {
+        // A stop during the pre-start hook stops the old run as well, which
+        // may have exited by now.
+        select {
+        case <-old.done:
+            pm.retiring = nil
+        default:
+            if pm.stopRequested || pm.finishing {
+                pm.retiring = nil
+            } else {
+                pm.restoreLocked(old)
+            }
+        }
+        pm.mu.Unlock()
+        return err
+    }
+    next := pm.done
+    pm.mu.Unlock()
+
+    if err := pm.waitUntilHealthy(ctx, next); err != nil {
+        return pm.abortBlueGreen(old, next, stopTimeout, err)
+    }
+
+    log.Printf("Blue/green restart of %q: new instance is healthy, stopping PID %d.", pm.name, old.cmd.Process.Pid)
+    pm.retire(old, stopTimeout)
+    pm.mu.Lock()
+    pm.restartsTotal++
+    pm.mu.Unlock()
+    return nil
+}
+
+// waitUntilHealthy waits for the run that done belongs to to pass a health
+// check, and fails if it exits first or ctx is done. With a start timeout
+// the health check stops a run that takes too long; otherwise the wait ends
+// after blueGreenReadyTimeout.
+func (pm *ProcessManager) waitUntilHealthy(ctx context.Context, done chan struct{}) error {
+    var deadline <-chan time.Time
+    if pm.healthCheck.StartTimeout <= 0 {
+        timer := time.NewTimer(blueGreenReadyTimeout)
+        defer timer.Stop()
+        deadline = timer.C
+    }
+    ticker := time.NewTicker(blueGreenPollInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-done:
+            pm.mu.Lock()
+            defer pm.mu.Unlock()
+            if pm.lastError != "" {
+                return fmt.Errorf("new instance exited before becoming healthy: %s", pm.lastError)
+            }
+            return errors.New("new instance exited before becoming healthy")
+        case <-deadline:
+            return fmt.Errorf("new instance did not pass a health check within %v", blueGreenReadyTimeout)
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-ticker.C:
+        }
+
+        pm.mu.Lock()
+        healthy := pm.done == done && pm.health != nil && pm.health.Healthy
+        pm.mu.Unlock()
+        if healthy {
+            return nil
+        }
+    }
+}
+
+// abortBlueGreen stops the new run that next belongs to after it failed with
+// cause, and puts old back in place. If the new run was stopped on request
+// or replaced meanwhile, old is stopped as well instead.
+func (pm *ProcessManager) abortBlueGreen(old *retiringRun, next chan struct{}, stopTimeout time.Duration, cause error) error {
+    pm.mu.Lock()
+    aborted := pm.done == next && pm.status == StatusRunning && !pm.stopRequested
+    if aborted {
+        pm.stopRequested = true
+        if err := pm.stopWithTimeoutLocked(pm.stopSignal, stopTimeout); err != nil {
+            log.Printf("Failed to stop new instance: %v", err)
+        }
+    }
+    pm.mu.Unlock()
+    <-next
+
+    pm.mu.Lock()
+    if pm.done != next || (pm.status == StatusStopped && !aborted) {
+        pm.mu.Unlock()
+        log.Printf("Blue/green restart of %q was interrupted, stopping PID %d.", pm.name, old.cmd.Process.Pid)
+        pm.retire(old, stopTimeout)
+        return fmt.Errorf("blue/green restart interrupted: %w", cause)
+    }
+    defer pm.mu.Unlock()
+    select {
+    case <-old.done:
+        pm.retiring = nil
+        return fmt.Errorf("blue/green restart failed and the old instance has exited: %w", cause)
+    default:
+    }
+    pm.restoreLocked(old)
+    log.Printf("Blue/green restart of %q failed, keeping PID %d: %v", pm.name, old.cmd.Process.Pid, cause)
+    return fmt.Errorf("blue/green restart failed: %w", cause)
+}
+
+// restoreLocked makes old the current run again. The caller must hold pm.mu.
+func (pm *ProcessManager) restoreLocked(old *retiringRun) {
+    // The watchers of the old run stop once a new run has replaced it; they
+    // are still going if the new one never started.
+    replaced := pm.done != old.done
+    pm.cancelRestartLocked()
+    pm.retiring = nil
+    pm.cmd = old.cmd
+    pm.process = old.cmd.Process
+    pm.ppid = old.ppid
+    pm.stdin = old.stdin
+    pm.spool = old.spool
+    pm.runArgs = old.runArgs
+    pm.startedAt = old.startedAt
+    pm.health = old.health
+    pm.runtimeTimer = old.runtimeTimer
+    pm.done = old.done
+
+    pm.status = StatusRunning
+    pm.exitCode = nil
+    pm.exitSignal = 0
+    pm.exitedAt = time.Time{}
+    pm.lastStats = nil
+    pm.stopRequested = false
+    pm.killSent = false
+    pm.termination = ""
+    pm.timedOut = false
+    pm.unhealthy = ""
+    pm.lastError = ""
+    pm.persistLocked()
+
+    if !replaced {
+        return
+    }
+    if pm.healthCheck.enabled() {
+        go pm.watchHealth(old.cmd, old.done)
+    }
+    if pm.idleTimeout > 0 {
+        go pm.watchIdle(old.done)
+    }
+}
+
+// retire stops the replaced run old, escalating to SIGKILL after
+// stopTimeout, and waits for it to exit.
+func (pm *ProcessManager) retire(old *retiringRun, stopTimeout time.Duration) {
+    if old.runtimeTimer != nil {
+        old.runtimeTimer.Stop()
+    }
+    select {
+    case <-old.done:
+    default:
+        pm.stopRetiring(old, stopTimeout)
+    }
+
+    pm.mu.Lock()
+    pm.retiring = nil
+    pm.mu.Unlock()
+}
+
+// stopRetiring signals the replaced run old to stop and waits for it to
+// exit, killing it once stopTimeout has passed.
+func (pm *ProcessManager) stopRetiring(old *retiringRun, stopTimeout time.Duration) {
+    proc := old.cmd.Process
+    pm.mu.Lock()
+    old.stopping = true
+    pm.mu.Unlock()
+    if err := pm.signalProcess(proc, pm.stopSignal); err != nil {
+        log.Printf("Failed to send %v to previous instance with PID %d: %v", pm.stopSignal, proc.Pid, err)
+    }
+    select {
+    case <-old.done:
+    case <-time.After(stopTimeout):
+        log.Printf("Previous instance did not exit within %v, sent SIGKILL to PID: %d", stopTimeout, proc.Pid)
+        pm.mu.Lock()
+        old.killed = true
+        pm.mu.Unlock()
+        if err := pm.signalProcess(proc, syscall.SIGKILL); err != nil {
+            log.Printf("Failed to send SIGKILL to process with PID %d: %v", proc.Pid, err)
+        }
+        <-old.done
+    }
+}
+
+// recordRetiredExitLocked completes the history event of the replaced run
+// with the given PID, given the error of waiting for it. Unlike the exit of
+// the current run it leaves the status of the manager alone. The caller must
+// hold pm.mu.
+func (pm *ProcessManager) recordRetiredExitLocked(pid int, err error) {
+    ev := pm.runEventLocked(pid)
+    if ev == nil {
+        return
+    }
+    exitedAt := time.Now()
+    ev.ExitedAt = &exitedAt
+    ev.Status = StatusSuccess
+    ev.Reason = api.TerminationExited
+    var exitErr *exec.ExitError
+    switch {
+    case err == nil:
+        code := 0
+        ev.ExitCode = &code
+    case errors.As(err, &exitErr):
+        code := exitErr.ExitCode()
+        ev.ExitCode = &code
+        if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+            ev.Reason = api.TerminationSignaled
+        }
+        fallthrough
+    default:
+        ev.Status = StatusFailed
+        ev.Error = err.Error()
+    }
+
+    if old := pm.retiring; old != nil && old.cmd.Process.Pid == pid && old.stopping {
+        // Dying from the stop signal we sent is an intentional stop.
+        ev.Reason = api.TerminationStopped
+        if old.killed {
+            ev.Reason = api.TerminationKilled
+        }
+        if ev.Status == StatusFailed {
+            ev.Status = StatusStopped
+            ev.Error = ""
+        }
+    }
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "bytes"
+    "log"
+    "sync"
+)
+
+// subscriberBuffer is how many lines a subscriber may fall behind before it
+// starts missing lines.
+const subscriberBuffer = 64
+
+// logBroker fans out log lines to any number of subscribers, such as /ws,
+// /log/stream and /log?follow=true clients. Subscribers only receive lines
+// published after they subscribed. Each has a buffered channel; a subscriber
+// that is not keeping up misses lines rather than blocking the child's
+// output, and the lines it missed are counted.
+type logBroker struct {
+    mu      sync.Mutex
+    subs    map[chan string]*logSubscriber
+    dropped uint64 // Lines missed by any subscriber, including ones since unsubscribed.
+}
+
+// logSubscriber is the bookkeeping of one subscription.
+type logSubscriber struct {
+    dropped uint64
+}
+
+// Publish sends line to every subscriber whose buffer has room.
+func (b *logBroker) Publish(line string) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    for ch, sub := range b.subs {
+        select {
+        case ch <- line:
+        default:
+            sub.dropped++
+            b.dropped++
+        }
+    }
+}
+
+// Subscribe registers a new subscriber and returns its channel. The
+// subscriber must be removed with Unsubscribe once it stops reading.
+func (b *logBroker) Subscribe() chan string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.subs == nil {
+        b.subs = make(map[chan string]*logSubscriber)
+    }
+    ch := make(chan string, subscriberBuffer)
+    b.subs[ch] = &logSubscriber{}
+    return ch
+}
+
+// Unsubscribe removes a subscriber and closes its channel. Removing one
+// that is already gone does nothing.
+func (b *logBroker) Unsubscribe(ch chan string) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    sub, ok := b.subs[ch]
+    if !ok {
+        return
+    }
+    delete(b.subs, ch)
+    close(ch)
+    if sub.dropped > 0 {
+        log.Printf("Log subscriber missed %d lines it did not read fast enough.", sub.dropped)
+    }
+}
+
+// Dropped returns how many lines subscribers have missed in total.
+func (b *logBroker) Dropped() uint64 {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.dropped
+}
+
+// lineSplitter reassembles lines from chunks that may split them, holding
+// back an incomplete tail until its newline arrives.
+type lineSplitter struct {
+    pending []byte
+}
+
+// Feed appends chunk and returns the lines it completed, without their
+// line endings.
+func (s *lineSplitter) Feed(chunk []byte) []string {
+    s.pending = append(s.pending, chunk...)
+    var lines []string
+    for {
+        i := bytes.IndexByte(s.pending, '\n')
+        if i < 0 {
+            return lines
+        }
+        lines = append(lines, string(bytes.TrimSuffix(s.pending[:i], []byte("\r"))))
+        s.pending = s.pending[i+1:]
+    }
+}
+
+// Flush returns the incomplete tail held back, if any, and clears it.
+func (s *lineSplitter) Flush() (string, bool) {
+    if len(s.pending) == 0 {
+        return "", false
+    }
+    line := string(bytes.TrimSuffix(s.pending, []byte("\r")))
+    s.pending = nil
+    return line, true
+}
+
+// lineBroadcastWriter publishes each complete line written to one output
+// stream as soon as its newline arrives, however the child's writes split
+// it. Each stream needs its own writer so partial lines of stdout and stderr
+// never mix.
+type lineBroadcastWriter struct {
+    b     *logBroker
+    lines lineSplitter
+}
+
+func (w *lineBroadcastWriter) Write(p []byte) (int, error) {
+    for _, line := range w.lines.Feed(p) {
+        w.b.Publish(line)
+    }
+    return len(p), nil
+}
+
+// Flush publishes a final line that was not terminated by a newline.
+func (w *lineBroadcastWriter) Flush() {
+    if line, ok := w.lines.Flush(); ok {
+        w.b.Publish(line)
+    }
+}
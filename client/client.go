@@ -0,0 +1,336 @@
+// Package client is a Go client for the gowork HTTP API.
+package client
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+
+    "gowork/api"
+)
+
+// Client talks to a gowork manager. The zero value is not usable; create
+// one with New.
+type Client struct {
+    baseURL    string
+    token      string
+    process    string
+    httpClient *http.Client
+}
+
+// Option configures a Client created by New.
+type Option func(*Client)
+
+// WithToken authenticates every request with the given bearer token.
+func WithToken(token string) Option {
+    return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient sends requests through hc instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+    return func(c *Client) { c.httpClient = hc }
+}
+
+// New returns a Client for the manager listening at baseURL, for example
+// "http://localhost:8080". Requests address the primary process.
+func New(baseURL string, opts ...Option) *Client {
+    c := &Client{
+        baseURL:    strings.TrimSuffix(baseURL, "/"),
+        httpClient: http.DefaultClient,
+    }
+    for _, opt := range opts {
+        opt(c)
+    }
+    return c
+}
+
+// Process returns a copy of c whose requests address the process with the
+// given name instead of the primary one.
+func (c *Client) Process(name string) *Client {
+    pc := *c
+    pc.process = name
+    return &pc
+}
+
+// Error is returned when the server answers with a non-2xx status.
+type Error struct {
+    StatusCode int
+    Code       string // One of the api.Code constants; empty if the server sent none.
+    Message    string
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("gowork: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsConflict reports whether err is a 409 Conflict from the server, returned
+// when the process is in the wrong state for the operation.
+func IsConflict(err error) bool {
+    var e *Error
+    return errors.As(err, &e) && e.StatusCode == http.StatusConflict
+}
+
+// HasCode reports whether err is an error response with the given code,
+// such as api.CodeNotRunning.
+func HasCode(err error, code string) bool {
+    var e *Error
+    return errors.As(err, &e) && e.Code == code
+}
+
+// Owns reports whether the manager controls the process with the given PID,
+// and whether a process with that PID is alive at all.
+func (c *Client) Owns(ctx context.Context, pid int) (api.Ownership, error) {
+    var answer api.Ownership
+    err := c.getJSON(ctx, c.processPath("owns")+"?pid="+strconv.Itoa(pid), &answer)
+    return answer, err
+}
+
+// ErrReplicated is returned by Status for a process run as several
+// replicas, whose status is a summary to be fetched with ReplicaSummary.
+var ErrReplicated = errors.New("gowork: process has replicas; use ReplicaSummary")
+
+// ErrNotReplicated is returned by ReplicaSummary for a process run as a
+// single instance, whose status is to be fetched with Status.
+var ErrNotReplicated = errors.New("gowork: process has no replicas; use Status")
+
+// Status returns the current status of the process. It fails with
+// ErrReplicated for a process with replicas.
+func (c *Client) Status(ctx context.Context) (api.StatusReport, error) {
+    var report api.StatusReport
+    err := c.getStatus(ctx, true, &report)
+    return report, err
+}
+
+// ReplicaSummary returns the combined status of the replicas of the
+// process. It fails with ErrNotReplicated for a process without replicas.
+func (c *Client) ReplicaSummary(ctx context.Context) (api.ReplicaSummary, error) {
+    var summary api.ReplicaSummary
+    err := c.getStatus(ctx, false, &summary)
+    return summary, err
+}
+
+// getStatus decodes /status into v after checking that it describes a
+// single instance or, unless single is set, a group of replicas.
+func (c *Client) getStatus(ctx context.Context, single bool, v any) error {
+    path := c.processPath("status")
+    body, err := c.do(ctx, http.MethodGet, path)
+    if err != nil {
+        return err
+    }
+    // Only a replica summary has a replicas field.
+    var shape struct {
+        Replicas *int `json:"replicas"`
+    }
+    if err := c.decode(path, body, &shape); err != nil {
+        return err
+    }
+    switch {
+    case single && shape.Replicas != nil:
+        return ErrReplicated
+    case !single && shape.Replicas == nil:
+        return ErrNotReplicated
+    }
+    return c.decode(path, body, v)
+}
+
+// Stats samples the CPU and memory usage of the running process.
+func (c *Client) Stats(ctx context.Context) (api.ProcessStats, error) {
+    var stats api.ProcessStats
+    err := c.getJSON(ctx, c.processPath("stats"), &stats)
+    return stats, err
+}
+
+// History returns the recent runs of the process, oldest first.
+func (c *Client) History(ctx context.Context) ([]api.ProcessEvent, error) {
+    var history []api.ProcessEvent
+    err := c.getJSON(ctx, c.processPath("history"), &history)
+    return history, err
+}
+
+// Processes lists every managed process with its status.
+func (c *Client) Processes(ctx context.Context) ([]api.ProcessInfo, error) {
+    var processes []api.ProcessInfo
+    err := c.getJSON(ctx, "/processes", &processes)
+    return processes, err
+}
+
+// Version returns the build information of the manager.
+func (c *Client) Version(ctx context.Context) (api.VersionInfo, error) {
+    var info api.VersionInfo
+    err := c.getJSON(ctx, "/version", &info)
+    return info, err
+}
+
+// Logs returns the retained combined output of the process.
+func (c *Client) Logs(ctx context.Context) (string, error) {
+    body, err := c.do(ctx, http.MethodGet, c.processPath("log"))
+    return string(body), err
+}
+
+// Start starts the process.
+func (c *Client) Start(ctx context.Context) error {
+    _, err := c.do(ctx, http.MethodPost, c.processPath("start"))
+    return err
+}
+
+// Stop sends the stop signal to the process. It does not wait for the
+// process to exit.
+func (c *Client) Stop(ctx context.Context) error {
+    _, err := c.do(ctx, http.MethodPost, c.processPath("stop"))
+    return err
+}
+
+// StopAndWait sends the stop signal and waits for the process to exit,
+// returning its final status. The server gives up after its default timeout.
+func (c *Client) StopAndWait(ctx context.Context) (api.StatusReport, error) {
+    var report api.StatusReport
+    body, err := c.do(ctx, http.MethodPost, c.processPath("stop")+"?wait=true")
+    if err != nil {
+        return report, err
+    }
+    err = json.Unmarshal(body, &report)
+    return report, err
+}
+
+// Restart stops the process, waits for it to exit and starts it again.
+func (c *Client) Restart(ctx context.Context) error {
+    _, err := c.do(ctx, http.MethodPost, c.processPath("restart"))
+    return err
+}
+
+// RestartBlueGreen starts a new instance of the process and stops the old
+// one only once the new one passes its health check; if it does not, the old
+// one keeps running and an error is returned.
+func (c *Client) RestartBlueGreen(ctx context.Context) error {
+    _, err := c.do(ctx, http.MethodPost, c.processPath("restart")+"?strategy=bluegreen")
+    return err
+}
+
+// Drain asks the process to finish its current work and accept no more.
+// Stop it afterwards with Stop, or wait for it to exit on its own.
+func (c *Client) Drain(ctx context.Context) error {
+    _, err := c.do(ctx, http.MethodPost, c.processPath("drain"))
+    return err
+}
+
+// SetArgs replaces the arguments of the process. Updating a running process
+// fails with a conflict unless force is set, in which case the process is
+// restarted with the new arguments.
+func (c *Client) SetArgs(ctx context.Context, args []string, force bool) error {
+    body, err := json.Marshal(api.ConfigUpdate{Args: &args, Force: force})
+    if err != nil {
+        return err
+    }
+    _, err = c.doBody(ctx, http.MethodPost, c.processPath("config"), body)
+    return err
+}
+
+// AppendArg adds arg to the end of the arguments of the process. As with
+// SetArgs, a running process is only updated, by restarting it, if force is set.
+func (c *Client) AppendArg(ctx context.Context, arg string, force bool) error {
+    body, err := json.Marshal(api.ArgAppend{Arg: &arg, Force: force})
+    if err != nil {
+        return err
+    }
+    _, err = c.doBody(ctx, http.MethodPost, c.processPath("config/args/append"), body)
+    return err
+}
+
+// ClearLogs discards the output captured so far.
+func (c *Client) ClearLogs(ctx context.Context) error {
+    _, err := c.do(ctx, http.MethodPost, c.processPath("log/clear"))
+    return err
+}
+
+// Exit stops every process, waits for them to exit and shuts the manager
+// down. The report lists the processes that did not exit in time.
+func (c *Client) Exit(ctx context.Context) (api.ExitReport, error) {
+    var report api.ExitReport
+    body, err := c.do(ctx, http.MethodPost, "/exit")
+    if err != nil {
+        return report, err
+    }
+    err = json.Unmarshal(body, &report)
+    return report, err
+}
+
+// Kill sends SIGKILL to the process.
+func (c *Client) Kill(ctx context.Context) error {
+    _, err := c.do(ctx, http.MethodPost, c.processPath("kill"))
+    return err
+}
+
+// processPath returns the path of endpoint for the process addressed by c.
+func (c *Client) processPath(endpoint string) string {
+    if c.process == "" {
+        return "/" + endpoint
+    }
+    return "/process/" + url.PathEscape(c.process) + "/" + endpoint
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, v any) error {
+    body, err := c.do(ctx, http.MethodGet, path)
+    if err != nil {
+        return err
+    }
+    return c.decode(path, body, v)
+}
+
+// decode unmarshals the response body of path into v.
+func (c *Client) decode(path string, body []byte, v any) error {
+    if err := json.Unmarshal(body, v); err != nil {
+        return fmt.Errorf("gowork: decoding %s response: %w", path, err)
+    }
+    return nil
+}
+
+// do sends a request without a body and returns the response body, or an
+// *Error if the server did not answer with a 2xx status.
+func (c *Client) do(ctx context.Context, method, path string) ([]byte, error) {
+    return c.doBody(ctx, method, path, nil)
+}
+
+// doBody is like do, but sends body as a JSON request body if non-nil.
+func (c *Client) doBody(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+    var reqBody io.Reader
+    if body != nil {
+        reqBody = bytes.NewReader(body)
+    }
+    req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+    if err != nil {
+        return nil, err
+    }
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if c.token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.token)
+    }
+    resp, err := c.httpClient.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        apiErr := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(respBody))}
+        var errResp api.ErrorResponse
+        if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != "" {
+            apiErr.Code = errResp.Code
+            apiErr.Message = errResp.Error
+        }
+        return nil, apiErr
+    }
+    return respBody, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+)
+
+// FileConfig is the schema of the JSON file given by -config. Every field is
+// optional; durations use time.ParseDuration syntax such as "1.5s".
+type FileConfig struct {
+    Port         string            `json:"port"`
+    Socket       string            `json:"socket"`
+    AdminAddr    string            `json:"admin_addr"`
+    AllowIP      []string          `json:"allow_ip"`
+    TrustedProxy []string          `json:"trusted_proxy"`
+    Name         string            `json:"name"`
+    Executable   string            `json:"executable"`
+    WaitForExec  string            `json:"wait_for_exec"`
+    Args         []string          `json:"args"`
+    Replicas     *int              `json:"replicas"`
+    Workdir      string            `json:"workdir"`
+    Env          []string          `json:"env"`
+    Labels       map[string]string `json:"labels"`
+    EnvFile      string            `json:"env_file"`
+    EnvClean     *bool             `json:"env_clean"`
+    ExpandArgs   *bool             `json:"expand_args"`
+    Shell        *bool             `json:"shell"`
+    StopSignal   string            `json:"stop_signal"`
+    DrainSignal  string            `json:"drain_signal"`
+    DrainFile    string            `json:"drain_file"`
+    StopTimeout  string            `json:"stop_timeout"`
+    MaxRuntime   string            `json:"max_runtime"`
+    IdleTimeout  string            `json:"idle_timeout"`
+    LogMaxBytes  *int              `json:"log_max_bytes"`
+    LogMaxLine   *int              `json:"log_max_line"`
+    LogFile      string            `json:"log_file"`
+    LogFormat    string            `json:"log_format"`
+    Quiet        *bool             `json:"quiet"`
+    NoAutostart  *bool             `json:"no_autostart"`
+    WebhookURL   string            `json:"webhook_url"`
+    Watch        []string          `json:"watch"`
+    PreStart     string            `json:"pre_start"`
+    PostStop     string            `json:"post_stop"`
+    Restart      *RestartConfig    `json:"restart"`
+    Health       *HealthConfig     `json:"health"`
+    HTTP         *HTTPConfig       `json:"http"`
+}
+
+// RestartConfig is the restart policy section of a FileConfig.
+type RestartConfig struct {
+    MaxRetries   *int     `json:"max_retries"`
+    Backoff      string   `json:"backoff"`
+    Multiplier   *float64 `json:"multiplier"`
+    MaxDelay     string   `json:"max_delay"`
+    StablePeriod string   `json:"stable_period"`
+
+    CrashLoopThreshold string `json:"crash_loop_threshold"`
+    CrashLoopCount     *int   `json:"crash_loop_count"`
+
+    OnCodes    []int `json:"on_codes"`
+    NotOnCodes []int `json:"not_on_codes"`
+}
+
+// HealthConfig is the health check section of a FileConfig.
+type HealthConfig struct {
+    Command      string `json:"command"`
+    URL          string `json:"url"`
+    Interval     string `json:"interval"`
+    Timeout      string `json:"timeout"`
+    Retries      *int   `json:"retries"`
+    StartTimeout string `json:"start_timeout"`
+}
+
+// HTTPConfig is the API server section of a FileConfig.
+type HTTPConfig struct {
+    ReadTimeout  string `json:"read_timeout"`
+    WriteTimeout string `json:"write_timeout"`
+    IdleTimeout  string `json:"idle_timeout"`
+}
+
+// loadFileConfig reads and parses the config file at path. Unknown fields
+// are rejected so typos do not go unnoticed.
+func loadFileConfig(path string) (*FileConfig, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read config file: %w", err)
+    }
+
+    var cfg FileConfig
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&cfg); err != nil {
+        return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+    }
+    return &cfg, nil
+}
+
+// flagValues maps the settings present in the file to the command-line
+// flags they correspond to. Repeatable flags map to several values.
+func (c *FileConfig) flagValues() map[string][]string {
+    values := make(map[string][]string)
+    set := func(name, value string) {
+        if value != "" {
+            values[name] = []string{value}
+        }
+    }
+
+    set("port", c.Port)
+    set("socket", c.Socket)
+    set("admin-addr", c.AdminAddr)
+    if len(c.AllowIP) > 0 {
+        values["allow-ip"] = c.AllowIP
+    }
+    if len(c.TrustedProxy) > 0 {
+        values["trusted-proxy"] = c.TrustedProxy
+    }
+    set("name", c.Name)
+    if c.Replicas != nil {
+        set("replicas", strconv.Itoa(*c.Replicas))
+    }
+    set("wait-for-exec", c.WaitForExec)
+    set("workdir", c.Workdir)
+    if len(c.Env) > 0 {
+        values["env"] = c.Env
+    }
+    if len(c.Labels) > 0 {
+        pairs := labelPairs(c.Labels)
+        for i := 0; i < len(pairs); i += 2 {
+            values["label"] = append(values["label"], pairs[i]+"="+pairs[i+1])
+        }
+    }
+    set("env-file", c.EnvFile)
+    if c.EnvClean != nil {
+        set("env-clean", strconv.FormatBool(*c.EnvClean))
+    }
+    if c.ExpandArgs != nil {
+        set("expand-args", strconv.FormatBool(*c.ExpandArgs))
+    }
+    if c.Shell != nil {
+        set("shell", strconv.FormatBool(*c.Shell))
+    }
+    set("stop-signal", c.StopSignal)
+    set("drain-signal", c.DrainSignal)
+    set("drain-file", c.DrainFile)
+    set("stop-timeout", c.StopTimeout)
+    set("max-runtime", c.MaxRuntime)
+    set("idle-timeout", c.IdleTimeout)
+    if c.LogMaxBytes != nil {
+        set("log-max-bytes", strconv.Itoa(*c.LogMaxBytes))
+    }
+    if c.LogMaxLine != nil {
+        set("log-max-line", strconv.Itoa(*c.LogMaxLine))
+    }
+    set("log-file", c.LogFile)
+    set("log-format", c.LogFormat)
+    if c.Quiet != nil {
+        set("quiet", strconv.FormatBool(*c.Quiet))
+    }
+    if c.NoAutostart != nil {
+        set("no-autostart", strconv.FormatBool(*c.NoAutostart))
+    }
+    set("webhook-url", c.WebhookURL)
+    if len(c.Watch) > 0 {
+        values["watch"] = c.Watch
+    }
+    set("pre-start", c.PreStart)
+    set("post-stop", c.PostStop)
+    if r := c.Restart; r != nil {
+        if r.MaxRetries != nil {
+            set("max-retries", strconv.Itoa(*r.MaxRetries))
+        }
+        set("restart-backoff", r.Backoff)
+        if r.Multiplier != nil {
+            set("restart-backoff-multiplier", strconv.FormatFloat(*r.Multiplier, 'g', -1, 64))
+        }
+        set("restart-backoff-max", r.MaxDelay)
+        set("restart-stable-period", r.StablePeriod)
+        set("crash-loop-threshold", r.CrashLoopThreshold)
+        if r.CrashLoopCount != nil {
+            set("crash-loop-count", strconv.Itoa(*r.CrashLoopCount))
+        }
+        onCodes, notOnCodes := exitCodeList(r.OnCodes), exitCodeList(r.NotOnCodes)
+        set("restart-on-codes", onCodes.String())
+        set("no-restart-on-codes", notOnCodes.String())
+    }
+    if h := c.Health; h != nil {
+        set("health-cmd", h.Command)
+        set("health-url", h.URL)
+        set("health-interval", h.Interval)
+        set("health-timeout", h.Timeout)
+        if h.Retries != nil {
+            set("health-retries", strconv.Itoa(*h.Retries))
+        }
+        set("start-timeout", h.StartTimeout)
+    }
+    if h := c.HTTP; h != nil {
+        set("http-read-timeout", h.ReadTimeout)
+        set("http-write-timeout", h.WriteTimeout)
+        set("http-idle-timeout", h.IdleTimeout)
+    }
+    return values
+}
+
+// apply sets every flag the file configures unless it was given explicitly
+// on the command line, so command-line flags take precedence.
+func (c *FileConfig) apply(fs *flag.FlagSet) error {
+    explicit := make(map[string]bool)
+    fs.Visit(func(f *flag.Flag) {
+        explicit[f.Name] = true
+    })
+
+    for name, values := range c.flagValues() {
+        if explicit[name] {
+            continue
+        }
+        for _, value := range values {
+            if err := fs.Set(name, value); err != nil {
+                return fmt.Errorf("invalid config value for %s: %w", name, err)
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/user"
+    "strconv"
+    "syscall"
+)
+
+// resolveCredential looks up the user and group a process should run as,
+// each given as a name or numeric ID. Without a group the user's primary
+// group is used; without a user the manager's user is kept. Switching
+// requires the manager to run as root unless it changes nothing.
+func resolveCredential(userName, groupName string) (*syscall.Credential, error) {
+    if userName == "" && groupName == "" {
+        return nil, nil
+    }
+
+    cred := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+    if userName != "" {
+        u, err := lookupUser(userName)
+        if err != nil {
+            return nil, err
+        }
+        uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+        gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+        cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+
+        // Keep the user's supplementary groups, as a login would.
+        groupIDs, err := u.GroupIds()
+        if err != nil {
+            return nil, fmt.Errorf("failed to look up groups of user %s: %w", userName, err)
+        }
+        for _, id := range groupIDs {
+            if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+                cred.Groups = append(cred.Groups, uint32(gid))
+            }
+        }
+    } else {
+        cred.NoSetGroups = true
+    }
+    if groupName != "" {
+        g, err := lookupGroup(groupName)
+        if err != nil {
+            return nil, err
+        }
+        gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+        cred.Gid = uint32(gid)
+    }
+
+    if os.Geteuid() != 0 && (cred.Uid != uint32(os.Geteuid()) || cred.Gid != uint32(os.Getegid())) {
+        return nil, fmt.Errorf("running processes as uid %d gid %d requires the manager to run as root", cred.Uid, cred.Gid)
+    }
+    return cred, nil
+}
+
+// lookupUser finds a user by name, or by ID if name is numeric.
+func lookupUser(name string) (*user.User, error) {
+    u, err := user.Lookup(name)
+    if err != nil {
+        if _, numErr := strconv.Atoi(name); numErr == nil {
+            u, err = user.LookupId(name)
+        }
+    }
+    if err != nil {
+        return nil, fmt.Errorf("unknown user %s: %w", name, err)
+    }
+    return u, nil
+}
+
+// lookupGroup finds a group by name, or by ID if name is numeric.
+func lookupGroup(name string) (*user.Group, error) {
+    g, err := user.LookupGroup(name)
+    if err != nil {
+        if _, numErr := strconv.Atoi(name); numErr == nil {
+            g, err = user.LookupGroupId(name)
+        }
+    }
+    if err != nil {
+        return nil, fmt.Errorf("unknown group %s: %w", name, err)
+    }
+    return g, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+    "bytes"
+    _ "embed"
+    "net/http"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// dashboardReadOnlyHTML is the page for a listener that does not serve the
+// control endpoints, which shows no buttons for them.
+var dashboardReadOnlyHTML = bytes.Replace(dashboardHTML, []byte(`data-controls="true"`), []byte(`data-controls="false"`), 1)
+
+// makeDashboardHandler serves a page showing the status and recent output
+// of every process, with buttons for the control endpoints if controls is
+// set. The page holds no data itself; it calls the API, sending the token
+// entered on it.
+func makeDashboardHandler(controls bool) http.HandlerFunc {
+    page := dashboardReadOnlyHTML
+    if controls {
+        page = dashboardHTML
+    }
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write(page)
+    }
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+)
+
+// dryRun checks that every process definition could be launched and that
+// the server port is free, without starting anything. It returns all
+// problems found, joined.
+func dryRun(defs []ProcessDefinition, port string) error {
+    var errs []error
+    for _, def := range defs {
+        fail := func(err error) {
+            errs = append(errs, fmt.Errorf("process %q: %w", def.Name, err))
+        }
+        if err := validateEnv(def.Env); err != nil {
+            fail(err)
+        }
+        if err := validateArgs(def.Args); err != nil {
+            fail(err)
+        }
+        if path, err := resolveExecutable(def.Path); err != nil {
+            fail(err)
+        } else if info, err := os.Stat(path); err != nil {
+            fail(err)
+        } else if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+            fail(fmt.Errorf("%s is not an executable file", path))
+        }
+        if def.Workdir != "" {
+            if info, err := os.Stat(def.Workdir); err != nil || !info.IsDir() {
+                fail(fmt.Errorf("working directory %s does not exist or is not a directory", def.Workdir))
+            }
+        }
+        if def.LogFile != "" {
+            if info, err := os.Stat(filepath.Dir(def.LogFile)); err != nil || !info.IsDir() {
+                fail(fmt.Errorf("directory of log file %s does not exist", def.LogFile))
+            }
+        }
+    }
+
+    ln, err := net.Listen("tcp", ":"+port)
+    if err != nil {
+        errs = append(errs, fmt.Errorf("port %s: %w", port, err))
+    } else {
+        ln.Close()
+    }
+    return errors.Join(errs...)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// validateEnv checks that every entry has the KEY=VALUE form.
+func validateEnv(env []string) error {
+    for _, kv := range env {
+        if i := strings.IndexByte(kv, '='); i <= 0 {
+            return fmt.Errorf("invalid environment variable %q: expected KEY=VALUE", kv)
+        }
+    }
+    return nil
+}
+
+// loadEnvFile reads KEY=VALUE lines from path. Blank lines and lines
+// starting with '#' are ignored.
+func loadEnvFile(path string) ([]string, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open env file: %w", err)
+    }
+    defer f.Close()
+
+    var env []string
+    scanner := bufio.NewScanner(f)
+    for lineNo := 1; scanner.Scan(); lineNo++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        if err := validateEnv([]string{line}); err != nil {
+            return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+        }
+        env = append(env, line)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read env file: %w", err)
+    }
+    return env, nil
+}
+
+// expandArgs replaces $VAR and ${VAR} in each of args with the value of VAR
+// in env, as os.Expand does; an undefined VAR expands to the empty string
+// and $$ to a literal $. Later entries of env take precedence, as they do
+// for the child.
+func expandArgs(args, env []string) []string {
+    values := make(map[string]string, len(env))
+    for _, kv := range env {
+        if k, v, ok := strings.Cut(kv, "="); ok {
+            values[k] = v
+        }
+    }
+    mapping := func(name string) string {
+        if name == "$" {
+            return "$"
+        }
+        return values[name]
+    }
+    expanded := make([]string, len(args))
+    for i, arg := range args {
+        expanded[i] = os.Expand(arg, mapping)
+    }
+    return expanded
+}
+
+// buildEnv returns the environment for a child process: the manager's own
+// environment followed by env, or only env when clean is set.
+func buildEnv(env []string, clean bool) []string {
+    if clean {
+        return append([]string{}, env...)
+    }
+    return append(os.Environ(), env...)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "syscall"
+)
+
+var (
+    exitMu    sync.Mutex
+    exitHooks []func()
+)
+
+// atExit registers fn to run when the manager terminates through exit, for
+// cleanup that a bare os.Exit would skip.
+func atExit(fn func()) {
+    exitMu.Lock()
+    defer exitMu.Unlock()
+    exitHooks = append(exitHooks, fn)
+}
+
+// exit runs the hooks registered with atExit, most recent first, and
+// terminates the manager with code. It is a variable so tests can replace it.
+var exit = func(code int) {
+    runExitHooks()
+    os.Exit(code)
+}
+
+// runExitHooks runs the hooks registered with atExit, most recent first.
+// Each runs at most once.
+func runExitHooks() {
+    exitMu.Lock()
+    hooks := exitHooks
+    exitHooks = nil
+    exitMu.Unlock()
+    for i := len(hooks) - 1; i >= 0; i-- {
+        hooks[i]()
+    }
+}
+
+// reexec replaces the manager with a fresh copy of its binary, started with
+// the same arguments and environment, after running the atExit hooks. If
+// the exec fails the manager exits with status 1.
+func reexec() {
+    runExitHooks()
+    self, err := os.Executable()
+    if err != nil {
+        log.Printf("Failed to restart the manager: %v", err)
+        os.Exit(1)
+    }
+    log.Printf("Restarting the manager: %s %s", self, strings.Join(os.Args[1:], " "))
+    err = syscall.Exec(self, os.Args, os.Environ())
+    log.Printf("Failed to restart the manager: %v", err)
+    os.Exit(1)
+}
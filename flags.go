@@ -0,0 +1,48 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+    return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+    *l = append(*l, value)
+    return nil
+}
+
+// exitCodeList is a flag.Value holding a comma-separated list of exit codes
+// such as "1,2,75". Setting it again replaces the list.
+type exitCodeList []int
+
+func (l *exitCodeList) String() string {
+    codes := make([]string, len(*l))
+    for i, code := range *l {
+        codes[i] = strconv.Itoa(code)
+    }
+    return strings.Join(codes, ",")
+}
+
+func (l *exitCodeList) Set(value string) error {
+    var codes []int
+    for _, field := range strings.Split(value, ",") {
+        field = strings.TrimSpace(field)
+        if field == "" {
+            continue
+        }
+        code, err := strconv.Atoi(field)
+        if err != nil || code < 0 || code > 255 {
+            return fmt.Errorf("invalid exit code %q", field)
+        }
+        codes = append(codes, code)
+    }
+    *l = codes
+    return nil
+}
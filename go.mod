@@ -1,3 +1,10 @@
 module gowork
 
 go 1.24.6
+
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/gorilla/websocket v1.5.3
+)
+
+require golang.org/x/sys v0.13.0 // indirect
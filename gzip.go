@@ -0,0 +1,43 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// acceptsGzip reports whether the client of r takes a gzip-compressed
+// response, going by its Accept-Encoding header. An encoding listed with
+// q=0 is refused, as is gzip when only * is listed with q=0.
+func acceptsGzip(r *http.Request) bool {
+    accepted := false
+    for _, header := range r.Header.Values("Accept-Encoding") {
+        for _, entry := range strings.Split(header, ",") {
+            name, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+            name = strings.ToLower(strings.TrimSpace(name))
+            if name != "gzip" && name != "x-gzip" && name != "*" {
+                continue
+            }
+            q := 1.0
+            for _, param := range strings.Split(params, ";") {
+                if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+                    if f, err := strconv.ParseFloat(v, 64); err == nil {
+                        q = f
+                    }
+                }
+            }
+            if name == "*" {
+                // An explicit gzip entry takes precedence over the wildcard.
+                if !accepted {
+                    accepted = q > 0
+                }
+                continue
+            }
+            if q <= 0 {
+                return false
+            }
+            accepted = true
+        }
+    }
+    return accepted
+}
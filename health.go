@@ -0,0 +1,186 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os/exec"
+    "strings"
+    "time"
+
+    "gowork/api"
+)
+
+// HealthCheck configures a probe run periodically while a process is
+// running: a shell command or an HTTP GET. A process failing Retries probes
+// in a row is stopped as unhealthy and restarted like a crashed one.
+type HealthCheck struct {
+    Command  string        // Shell command; exiting 0 means healthy.
+    URL      string        // URL whose GET must answer 2xx; used instead of Command if set.
+    Interval time.Duration // Time between probes.
+    Timeout  time.Duration // A probe running longer than this fails; 0 means no limit.
+    Retries  int           // Consecutive failures that stop the process as unhealthy; 0 never stops it.
+
+    // StartTimeout is how long a run has to pass its first probe; a run that
+    // does not is stopped and reported as unhealthy. 0 means no limit.
+    StartTimeout time.Duration
+}
+
+// HealthReport is the result of the most recent health probe.
+type HealthReport = api.HealthReport
+
+// maxProbeOutput bounds the probe output kept in a HealthReport.
+const maxProbeOutput = 512
+
+// watchHealth probes the run of cmd until done is closed, restarting the
+// process once the probe has failed too often. Like the hooks, the probe
+// shares the working directory and environment of the process.
+func (pm *ProcessManager) watchHealth(cmd *exec.Cmd, done chan struct{}) {
+    check := pm.healthCheck
+    ticker := time.NewTicker(check.Interval)
+    defer ticker.Stop()
+
+    // Until the first probe passes, failures count towards the start
+    // timeout rather than towards a restart.
+    started := check.StartTimeout <= 0
+    var startDeadline <-chan time.Time
+    if !started {
+        timer := time.NewTimer(check.StartTimeout)
+        defer timer.Stop()
+        startDeadline = timer.C
+    }
+
+    for {
+        select {
+        case <-done:
+            return
+        case <-startDeadline:
+            log.Printf("Process %q did not pass a health check within %v, stopping it.", pm.name, check.StartTimeout)
+            pm.failHealth(done, fmt.Sprintf("process did not pass a health check within %v", check.StartTimeout))
+            return
+        case <-ticker.C:
+        }
+
+        var healthy bool
+        var code int
+        var output string
+        if check.URL != "" {
+            healthy, code, output = runHTTPProbe(check)
+        } else {
+            healthy, output = runProbe(check, cmd)
+        }
+
+        pm.mu.Lock()
+        // The run may have ended while the probe ran.
+        if pm.done != done {
+            pm.mu.Unlock()
+            return
+        }
+        failures := 0
+        if pm.health != nil {
+            failures = pm.health.ConsecutiveFailures
+        }
+        if healthy {
+            failures = 0
+        } else {
+            failures++
+        }
+        pm.health = &HealthReport{
+            Healthy:             healthy,
+            CheckedAt:           time.Now(),
+            ConsecutiveFailures: failures,
+            StatusCode:          code,
+            Output:              output,
+        }
+        fail := started && !healthy && check.Retries > 0 && failures >= check.Retries && pm.status == StatusRunning
+        pm.mu.Unlock()
+
+        if healthy && !started {
+            started = true
+            startDeadline = nil
+            log.Printf("Process %q passed its first health check.", pm.name)
+        }
+
+        if !healthy {
+            log.Printf("Health check of %q failed (%d in a row): %s", pm.name, failures, output)
+        }
+        if fail {
+            log.Printf("Process %q failed %d health checks in a row, stopping it.", pm.name, failures)
+            pm.failHealth(done, fmt.Sprintf("process failed %d health checks in a row", failures))
+            return
+        }
+    }
+}
+
+// failHealth stops the run that done belongs to for failing its health
+// check, escalating to SIGKILL after the kill timeout. The run then ends as
+// unhealthy with reason as its error, and is restarted according to the
+// restart policy, like a run that crashed.
+func (pm *ProcessManager) failHealth(done chan struct{}, reason string) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if pm.done != done || pm.status != StatusRunning {
+        return
+    }
+    pm.unhealthy = reason
+    if err := pm.stopWithTimeoutLocked(pm.stopSignal, pm.killTimeout); err != nil {
+        log.Printf("Failed to stop unhealthy process: %v", err)
+    }
+}
+
+// enabled reports whether the check probes anything.
+func (c HealthCheck) enabled() bool {
+    return c.Command != "" || c.URL != ""
+}
+
+// runHTTPProbe sends one GET to the probe URL and reports whether it
+// answered 2xx, the status code (0 if no response arrived) and the reason
+// it failed.
+func runHTTPProbe(check HealthCheck) (bool, int, string) {
+    client := &http.Client{Timeout: check.Timeout}
+    resp, err := client.Get(check.URL)
+    if err != nil {
+        return false, 0, err.Error()
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, io.LimitReader(resp.Body, maxProbeOutput))
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return false, resp.StatusCode, resp.Status
+    }
+    return true, resp.StatusCode, ""
+}
+
+// runProbe runs the probe command once and reports whether it succeeded,
+// along with its trimmed output or the reason it failed.
+func runProbe(check HealthCheck, cmd *exec.Cmd) (bool, string) {
+    ctx := context.Background()
+    if check.Timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, check.Timeout)
+        defer cancel()
+    }
+    probe := exec.CommandContext(ctx, "/bin/sh", "-c", check.Command)
+    probe.Dir = cmd.Dir
+    probe.Env = cmd.Env
+    out, err := probe.CombinedOutput()
+
+    output := strings.TrimSpace(string(out))
+    if len(output) > maxProbeOutput {
+        output = output[len(output)-maxProbeOutput:]
+    }
+    if err != nil {
+        if ctx.Err() == context.DeadlineExceeded {
+            err = ctx.Err()
+        }
+        if output == "" {
+            output = err.Error()
+        } else {
+            output = err.Error() + ": " + output
+        }
+        return false, output
+    }
+    return true, output
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "time"
+
+    "gowork/api"
+)
+
+// defaultHistorySize is the number of runs kept when none is configured.
+const defaultHistorySize = 100
+
+// ProcessEvent records one run of a process, or one failed attempt to start it.
+type ProcessEvent = api.ProcessEvent
+
+// recordStartLocked appends an event for a run that has just started,
+// dropping the oldest event once the history is full. The caller must hold
+// pm.mu.
+func (pm *ProcessManager) recordStartLocked(pid int, startedAt time.Time) {
+    pm.appendEventLocked(ProcessEvent{PID: pid, StartedAt: startedAt, Status: pm.status})
+}
+
+// recordExitLocked completes the event of the run with the given PID, the
+// current one, with its exit. The caller must hold pm.mu.
+func (pm *ProcessManager) recordExitLocked(pid int, exitCode *int) {
+    ev := pm.runEventLocked(pid)
+    if ev == nil {
+        return
+    }
+    exitedAt := pm.exitedAt
+    ev.ExitedAt = &exitedAt
+    ev.ExitCode = exitCode
+    ev.Status = pm.status
+    ev.Error = pm.lastError
+    ev.Reason = pm.termination
+}
+
+// recordStartFailureLocked appends an event for a start attempt that failed
+// before a process was created. The caller must hold pm.mu.
+func (pm *ProcessManager) recordStartFailureLocked() {
+    pm.appendEventLocked(ProcessEvent{StartedAt: time.Now(), Status: pm.status, Error: pm.lastError})
+}
+
+// runEventLocked returns the event of the run with the given PID that has
+// not exited yet, or nil if it has dropped out of the history. Runs are not
+// always the latest event: one a blue/green restart replaces exits after its
+// successor has started. The caller must hold pm.mu.
+func (pm *ProcessManager) runEventLocked(pid int) *ProcessEvent {
+    for i := len(pm.history) - 1; i >= 0; i-- {
+        if ev := &pm.history[i]; ev.PID == pid && ev.ExitedAt == nil {
+            return ev
+        }
+    }
+    return nil
+}
+
+func (pm *ProcessManager) appendEventLocked(ev ProcessEvent) {
+    if pm.historySize <= 0 {
+        return
+    }
+    if len(pm.history) >= pm.historySize {
+        copy(pm.history, pm.history[1:])
+        pm.history = pm.history[:len(pm.history)-1]
+    }
+    pm.history = append(pm.history, ev)
+}
+
+// History returns the recorded runs of the process, oldest first.
+func (pm *ProcessManager) History() []ProcessEvent {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    return append([]ProcessEvent{}, pm.history...)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "os/exec"
+    "syscall"
+)
+
+// runHook runs a pre-start or post-stop hook command through /bin/sh to
+// completion. The hook shares the working directory, environment and output
+// writers of cmd, so its output lands in the process logs. The hook and
+// its subprocesses are killed if ctx is done before it finishes.
+func runHook(ctx context.Context, kind, command string, cmd *exec.Cmd) error {
+    hook := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+    hook.Dir = cmd.Dir
+    hook.Env = cmd.Env
+    hook.Stdout = cmd.Stdout
+    hook.Stderr = cmd.Stderr
+    // Cancelling the hook kills the commands it started along with the
+    // shell, which would otherwise keep its output open.
+    hook.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+    hook.Cancel = func() error { return syscall.Kill(-hook.Process.Pid, syscall.SIGKILL) }
+    hook.WaitDelay = outputWaitDelay
+    log.Printf("Running %s hook: %s", kind, command)
+    if err := hook.Run(); err != nil {
+        return fmt.Errorf("%s hook failed: %w", kind, err)
+    }
+    return nil
+}
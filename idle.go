@@ -0,0 +1,50 @@
+package main
+
+import (
+    "log"
+    "time"
+)
+
+// idleSinceLocked returns when the current run last showed activity: its
+// last output, or its start if it has written nothing since. The caller
+// must hold pm.mu.
+func (pm *ProcessManager) idleSinceLocked() time.Time {
+    if last := pm.logs.LastWrite(); last.After(pm.startedAt) {
+        return last
+    }
+    return pm.startedAt
+}
+
+// watchIdle stops the run that done belongs to once it has written no
+// output for the idle timeout, escalating to SIGKILL after the kill timeout.
+func (pm *ProcessManager) watchIdle(done chan struct{}) {
+    timer := time.NewTimer(pm.idleTimeout)
+    defer timer.Stop()
+
+    for {
+        select {
+        case <-done:
+            return
+        case <-timer.C:
+        }
+
+        pm.mu.Lock()
+        if pm.done != done || pm.status != StatusRunning {
+            pm.mu.Unlock()
+            return
+        }
+        idle := time.Since(pm.idleSinceLocked())
+        if idle < pm.idleTimeout {
+            // Output arrived meanwhile; check again when it would have gone stale.
+            pm.mu.Unlock()
+            timer.Reset(pm.idleTimeout - idle)
+            continue
+        }
+        log.Printf("Process %q produced no output for %v, stopping it.", pm.name, pm.idleTimeout)
+        if err := pm.stopWithTimeoutLocked(pm.stopSignal, pm.killTimeout); err != nil {
+            log.Printf("Failed to stop idle process: %v", err)
+        }
+        pm.mu.Unlock()
+        return
+    }
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// labelName matches the label names Prometheus accepts, so labels can be
+// exported on /metrics as they are.
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are used on /metrics by gowork itself.
+var reservedLabels = map[string]bool{"process": true, "status": true}
+
+// parseLabels turns key=value pairs into a label map.
+func parseLabels(pairs []string) (map[string]string, error) {
+    labels := make(map[string]string, len(pairs))
+    for _, pair := range pairs {
+        key, value, ok := strings.Cut(pair, "=")
+        if !ok {
+            return nil, fmt.Errorf("invalid label %q: expected KEY=VALUE", pair)
+        }
+        labels[key] = value
+    }
+    return labels, validateLabels(labels)
+}
+
+// validateLabels checks that every label name is valid and not reserved.
+func validateLabels(labels map[string]string) error {
+    for key := range labels {
+        if !labelName.MatchString(key) {
+            return fmt.Errorf("invalid label name %q: use letters, digits and underscores, not starting with a digit", key)
+        }
+        if reservedLabels[key] {
+            return fmt.Errorf("label name %q is reserved", key)
+        }
+    }
+    return nil
+}
+
+// labelPairs flattens labels into name/value pairs sorted by name.
+func labelPairs(labels map[string]string) []string {
+    keys := make([]string, 0, len(labels))
+    for key := range labels {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    pairs := make([]string, 0, 2*len(keys))
+    for _, key := range keys {
+        pairs = append(pairs, key, labels[key])
+    }
+    return pairs
+}
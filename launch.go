@@ -0,0 +1,102 @@
+package main
+
+import (
+    "fmt"
+    "os/exec"
+    "strconv"
+    "sync"
+    "syscall"
+    "unsafe"
+)
+
+// launchAttrs are process attributes Go cannot set through SysProcAttr,
+// applied by startWithAttrs.
+type launchAttrs struct {
+    umask  int // File creation mask; negative keeps the manager's.
+    nice   int // Niceness; 0 keeps the manager's.
+    limits []resourceLimit
+}
+
+// newLaunchAttrs collects the launch attributes of cfg.
+func newLaunchAttrs(cfg ProcessConfig) launchAttrs {
+    attrs := launchAttrs{umask: cfg.Umask, nice: cfg.Nice}
+    if cfg.RlimitNofile > 0 {
+        attrs.limits = append(attrs.limits, resourceLimit{"RLIMIT_NOFILE", syscall.RLIMIT_NOFILE, cfg.RlimitNofile})
+    }
+    if cfg.RlimitAS > 0 {
+        attrs.limits = append(attrs.limits, resourceLimit{"RLIMIT_AS", syscall.RLIMIT_AS, cfg.RlimitAS})
+    }
+    return attrs
+}
+
+// resourceLimit is one rlimit to apply to a process.
+type resourceLimit struct {
+    name     string // For error messages, such as "RLIMIT_NOFILE".
+    resource int
+    value    uint64 // Applied as both the soft and the hard limit.
+}
+
+// umaskMu serializes starts that change the umask. Go cannot set the umask
+// of a child alone, so it is set for the whole manager around the fork and
+// restored right after.
+var umaskMu sync.Mutex
+
+// startWithAttrs starts cmd with attrs applied. The niceness and limits
+// are set right after the fork, so anything the child spawns before then
+// escapes them. A child they cannot be applied to is killed rather than
+// left running without them.
+func startWithAttrs(cmd *exec.Cmd, attrs launchAttrs) error {
+    if attrs.umask >= 0 {
+        umaskMu.Lock()
+        old := syscall.Umask(attrs.umask)
+        err := cmd.Start()
+        syscall.Umask(old)
+        umaskMu.Unlock()
+        if err != nil {
+            return err
+        }
+    } else if err := cmd.Start(); err != nil {
+        return err
+    }
+
+    if err := applyAttrs(cmd.Process.Pid, attrs); err != nil {
+        cmd.Process.Kill()
+        cmd.Wait()
+        return err
+    }
+    return nil
+}
+
+// applyAttrs sets the niceness and limits of the process pid.
+func applyAttrs(pid int, attrs launchAttrs) error {
+    if attrs.nice != 0 {
+        if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, attrs.nice); err != nil {
+            return fmt.Errorf("failed to set niceness %d: %w", attrs.nice, err)
+        }
+    }
+    for _, l := range attrs.limits {
+        if err := prlimit(pid, l.resource, &syscall.Rlimit{Cur: l.value, Max: l.value}); err != nil {
+            return fmt.Errorf("failed to set %s to %d: %w", l.name, l.value, err)
+        }
+    }
+    return nil
+}
+
+// prlimit sets a resource limit of another process, which the syscall
+// package offers no wrapper for.
+func prlimit(pid, resource int, limit *syscall.Rlimit) error {
+    _, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(limit)), 0, 0, 0)
+    if errno != 0 {
+        return errno
+    }
+    return nil
+}
+
+// parseUmask parses an octal umask such as "022" or "0077".
+func parseUmask(s string) (int, error) {
+    mask, err := strconv.ParseUint(s, 8, 32)
+    if err != nil || mask > 0777 {
+        return 0, fmt.Errorf("invalid umask %q: want an octal value up to 0777", s)
+    }
+    return int(mask), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "os"
+    "strconv"
+    "syscall"
+    "time"
+)
+
+// maxPortFallback bounds how many successive ports are tried with -port-fallback.
+const maxPortFallback = 100
+
+// listen binds the server port. If the port is in use it either moves on to
+// the next free port when fallback is set, or keeps retrying with backoff
+// for up to retryFor.
+func listen(port string, retryFor time.Duration, fallback bool) (net.Listener, error) {
+    if fallback {
+        return listenFallback(port)
+    }
+
+    deadline := time.Now().Add(retryFor)
+    delay := 100 * time.Millisecond
+    for {
+        ln, err := net.Listen("tcp", ":"+port)
+        remaining := time.Until(deadline)
+        if err == nil || !errors.Is(err, syscall.EADDRINUSE) || remaining <= 0 {
+            return ln, err
+        }
+        delay = min(delay, remaining)
+        log.Printf("Port %s is in use, retrying in %v...", port, delay)
+        time.Sleep(delay)
+        delay = min(delay*2, 2*time.Second)
+    }
+}
+
+// listenUnix binds a Unix socket at path. A socket file left behind by a
+// manager that did not exit cleanly is replaced, but one that a server still
+// accepts connections on is not, nor is any other kind of file.
+func listenUnix(path string) (net.Listener, error) {
+    if info, err := os.Lstat(path); err == nil {
+        if info.Mode().Type() != os.ModeSocket {
+            return nil, fmt.Errorf("%s exists and is not a socket", path)
+        }
+        if conn, err := net.Dial("unix", path); err == nil {
+            conn.Close()
+            return nil, fmt.Errorf("socket %s is in use", path)
+        }
+        if err := os.Remove(path); err != nil {
+            return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+        }
+    }
+    ln, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, err
+    }
+    // Closing the listener removes the socket file; exit paths that skip
+    // the server shutdown remove it themselves.
+    atExit(func() { os.Remove(path) })
+    return ln, nil
+}
+
+// listenFallback binds the first free port starting at port.
+func listenFallback(port string) (net.Listener, error) {
+    first, err := strconv.Atoi(port)
+    if err != nil {
+        return nil, fmt.Errorf("-port-fallback needs a numeric port: %w", err)
+    }
+    for p := first; p < first+maxPortFallback && p <= 65535; p++ {
+        ln, err := net.Listen("tcp", ":"+strconv.Itoa(p))
+        if err == nil {
+            if p != first {
+                log.Printf("Port %d is in use, using port %d instead.", first, p)
+            }
+            return ln, nil
+        }
+        if !errors.Is(err, syscall.EADDRINUSE) {
+            return nil, err
+        }
+    }
+    return nil, fmt.Errorf("no free port in %d-%d", first, first+maxPortFallback-1)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ringBuffer retains log output, keeping at most max bytes and discarding
+// the oldest data once full. A max of 0 keeps everything. It is not safe for
+// concurrent use; logStore serializes access to its buffers.
+type ringBuffer struct {
+    max       int
+    buf       []byte
+    start     int // Offset of the oldest byte once the buffer has wrapped.
+    truncated bool
+}
+
+// newRingBuffer returns a buffer retaining at most max bytes.
+func newRingBuffer(max int) *ringBuffer {
+    return &ringBuffer{max: max}
+}
+
+// Write appends p, overwriting the oldest data when the buffer is full.
+func (b *ringBuffer) Write(p []byte) (int, error) {
+    n := len(p)
+    if b.max <= 0 {
+        b.buf = append(b.buf, p...)
+        return n, nil
+    }
+
+    // A single write larger than the buffer only leaves its own tail.
+    if len(p) >= b.max {
+        b.buf = append(b.buf[:0], p[len(p)-b.max:]...)
+        b.start = 0
+        b.truncated = true
+        return n, nil
+    }
+
+    if room := b.max - len(b.buf); room > 0 {
+        if room > len(p) {
+            room = len(p)
+        }
+        b.buf = append(b.buf, p[:room]...)
+        p = p[room:]
+    }
+
+    for len(p) > 0 {
+        b.truncated = true
+        c := copy(b.buf[b.start:], p)
+        p = p[c:]
+        b.start = (b.start + c) % b.max
+    }
+    return n, nil
+}
+
+// String returns the retained data, oldest first.
+func (b *ringBuffer) String() string {
+    return string(b.buf[b.start:]) + string(b.buf[:b.start])
+}
+
+// Truncated reports whether any data has been discarded since the last Reset.
+func (b *ringBuffer) Truncated() bool {
+    return b.truncated
+}
+
+// SetMax changes the retention limit, dropping the oldest data if more
+// than max bytes are retained.
+func (b *ringBuffer) SetMax(max int) {
+    data := b.String()
+    truncated := b.truncated
+    b.max = max
+    b.Reset()
+    b.Write([]byte(data))
+    b.truncated = b.truncated || truncated
+}
+
+// Reset discards all retained data.
+func (b *ringBuffer) Reset() {
+    b.buf = b.buf[:0]
+    b.start = 0
+    b.truncated = false
+}
+
+// LogStream selects which part of the process output to read.
+type LogStream string
+
+const (
+    StreamCombined LogStream = "combined"
+    StreamStdout   LogStream = "stdout"
+    StreamStderr   LogStream = "stderr"
+)
+
+// logStore holds the captured output of a process: one buffer per stream
+// plus the combined output. The child's stdout and stderr are copied by
+// separate goroutines while API handlers read, so every access to the
+// buffers goes through the store's lock.
+type logStore struct {
+    mu        sync.Mutex
+    combined  *ringBuffer
+    stdout    *ringBuffer
+    stderr    *ringBuffer
+    lastWrite time.Time // When output last arrived; kept across Reset.
+
+    // stderrLines counts the lines written to stderr since the last Reset,
+    // including those the buffer has since discarded.
+    stderrLines int
+}
+
+// newLogStore returns a store whose buffers each retain at most max bytes.
+func newLogStore(max int) *logStore {
+    return &logStore{
+        combined: newRingBuffer(max),
+        stdout:   newRingBuffer(max),
+        stderr:   newRingBuffer(max),
+    }
+}
+
+// Writer returns a writer recording output of stream, which must be stdout
+// or stderr, into its own buffer and the combined one.
+func (s *logStore) Writer(stream LogStream) io.Writer {
+    if stream == StreamStderr {
+        return &streamWriter{store: s, own: s.stderr, lines: &s.stderrLines}
+    }
+    return &streamWriter{store: s, own: s.stdout}
+}
+
+// Snapshot returns the retained output of stream and whether older output
+// of that stream has been discarded.
+func (s *logStore) Snapshot(stream LogStream) (string, bool, error) {
+    var buf *ringBuffer
+    switch stream {
+    case StreamCombined:
+        buf = s.combined
+    case StreamStdout:
+        buf = s.stdout
+    case StreamStderr:
+        buf = s.stderr
+    default:
+        return "", false, fmt.Errorf("unknown log stream %q", stream)
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return buf.String(), buf.Truncated(), nil
+}
+
+// LastWrite returns when output last arrived, or the zero time if never.
+func (s *logStore) LastWrite() time.Time {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.lastWrite
+}
+
+// StderrLines returns how many lines have been written to stderr since the
+// last Reset.
+func (s *logStore) StderrLines() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.stderrLines
+}
+
+// SetMax changes the limit of every buffer to max bytes.
+func (s *logStore) SetMax(max int) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.combined.SetMax(max)
+    s.stdout.SetMax(max)
+    s.stderr.SetMax(max)
+}
+
+// Reset discards all retained output.
+func (s *logStore) Reset() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.combined.Reset()
+    s.stdout.Reset()
+    s.stderr.Reset()
+    s.stderrLines = 0
+}
+
+// streamWriter records one output stream of the child into its own buffer
+// and into the combined buffer of a logStore, so a chunk appears in both or
+// in neither.
+type streamWriter struct {
+    store *logStore
+    own   *ringBuffer
+    lines *int // Counts the newlines written, if set.
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+    w.store.mu.Lock()
+    defer w.store.mu.Unlock()
+    w.store.lastWrite = time.Now()
+    w.own.Write(p)
+    if w.lines != nil {
+        *w.lines += bytes.Count(p, []byte("\n"))
+    }
+    return w.store.combined.Write(p)
+}
+
+// consoleMu serializes JSON records written to the manager's stdout so
+// lines from different streams and processes never interleave.
+var consoleMu sync.Mutex
+
+// logRecord is the JSON form of one line of process output.
+type logRecord struct {
+    Stream    LogStream `json:"stream"`
+    Timestamp time.Time `json:"timestamp"`
+    Line      string    `json:"line"`
+}
+
+// jsonLineWriter re-emits complete lines written to it as JSON records
+// tagged with the stream name and the time the line was seen.
+type jsonLineWriter struct {
+    stream  LogStream
+    out     io.Writer
+    pending []byte
+}
+
+func (w *jsonLineWriter) Write(p []byte) (int, error) {
+    w.pending = append(w.pending, p...)
+    for {
+        i := bytes.IndexByte(w.pending, '\n')
+        if i < 0 {
+            break
+        }
+        record, _ := json.Marshal(logRecord{
+            Stream:    w.stream,
+            Timestamp: time.Now(),
+            Line:      string(bytes.TrimSuffix(w.pending[:i], []byte("\r"))),
+        })
+        consoleMu.Lock()
+        w.out.Write(append(record, '\n'))
+        consoleMu.Unlock()
+        w.pending = w.pending[i+1:]
+    }
+    return len(p), nil
+}
+
+// filterLines returns the lines of logs matching re (all of them if re is
+// nil), keeping only the last tail of those if tail is positive.
+func filterLines(logs string, re *regexp.Regexp, tail int) string {
+    lines := strings.SplitAfter(logs, "\n")
+    if lines[len(lines)-1] == "" {
+        lines = lines[:len(lines)-1]
+    }
+    if re != nil {
+        matched := lines[:0]
+        for _, line := range lines {
+            if re.MatchString(strings.TrimSuffix(line, "\n")) {
+                matched = append(matched, line)
+            }
+        }
+        lines = matched
+    }
+    if tail > 0 && len(lines) > tail {
+        lines = lines[len(lines)-tail:]
+    }
+    return strings.Join(lines, "")
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "log/slog"
+    "os"
+)
+
+// setLogFormat selects the format of the manager's own log messages. In
+// "json" mode the standard logger is routed through slog, so every
+// log.Printf becomes a record with time, level and message fields. The
+// child's output mirrored to stdout is not affected.
+func setLogFormat(format string) error {
+    switch format {
+    case "text":
+        return nil
+    case "json":
+        handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+            ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+                if len(groups) == 0 && a.Key == slog.MessageKey {
+                    a.Key = "message"
+                }
+                return a
+            },
+        })
+        slog.SetDefault(slog.New(handler))
+        // slog adds its own timestamp.
+        log.SetFlags(0)
+        return nil
+    default:
+        return fmt.Errorf("invalid -log-format %q: must be text or json", format)
+    }
+}
@@ -1,94 +1,691 @@
 package main
 
 import (
-    "bytes"
+    "compress/gzip"
+    "context"
     "encoding/json"
+    "errors"
     "flag"
     "fmt"
     "io"
     "log"
+    "net"
     "net/http"
     "os"
     "os/exec"
+    "os/signal"
+    "path/filepath"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
     "sync"
     "syscall"
+    "time"
+
+    "gowork/api"
 )
 
 // ProcessStatus defines the possible states of the managed process.
-type ProcessStatus string
+type ProcessStatus = api.ProcessStatus
 const (
-    StatusNotStarted ProcessStatus = "not_started"
-    StatusRunning    ProcessStatus = "running"
-    StatusSuccess    ProcessStatus = "success"
-    StatusFailed     ProcessStatus = "failed"
+    StatusNotStarted   = api.StatusNotStarted
+    StatusStarting     = api.StatusStarting
+    StatusRunning      = api.StatusRunning
+    StatusDraining     = api.StatusDraining
+    StatusSuccess      = api.StatusSuccess
+    StatusFailed       = api.StatusFailed
+    StatusTimedOut     = api.StatusTimedOut
+    StatusUnhealthy    = api.StatusUnhealthy
+    StatusStopped      = api.StatusStopped
+    StatusBackoff      = api.StatusBackoff
+    StatusCrashLooping = api.StatusCrashLooping
+)
+
+// Errors returned when an operation conflicts with the current process state.
+var (
+    ErrAlreadyRunning = errors.New("process is already running")
+    ErrNotRunning     = errors.New("process is not running")
+    // ErrNotStarted is the ErrNotRunning of a process that has never been
+    // started, so errors.Is(ErrNotStarted, ErrNotRunning) holds.
+    ErrNotStarted     = fmt.Errorf("%w: it has not been started yet", ErrNotRunning)
+    ErrStdinClosed    = errors.New("process stdin is closed")
+
+    ErrRestartInProgress = errors.New("a blue/green restart is already in progress")
+
+    // ErrStartCancelled is returned by a start whose pre-start hook was
+    // cancelled by a stop.
+    ErrStartCancelled = errors.New("start cancelled by a stop request")
 )
 
+// defaultKillTimeout is the grace period before SIGKILL when the manager
+// stops a process on its own and no stop timeout is configured.
+const defaultKillTimeout = 10 * time.Second
+
+// startGracePeriod is how long Start waits to see whether the process dies
+// right away, in which case its output is returned with the error.
+const startGracePeriod = 200 * time.Millisecond
+
+// outputWaitDelay is how long a run's output is still read after the process
+// exited. Subprocesses it left behind may hold its stdout or stderr open;
+// the run is reaped once this has passed anyway.
+const outputWaitDelay = 2 * time.Second
+
+// maxEarlyExitOutput bounds the output included in an early exit error.
+const maxEarlyExitOutput = 2048
+
+// RestartPolicy controls automatic restarts of a process that exits with an error.
+type RestartPolicy struct {
+    MaxRetries   int           // Maximum number of consecutive restarts; 0 disables restarting.
+    Backoff      time.Duration // Delay before the first restart attempt.
+    Multiplier   float64       // Factor applied to the delay after each attempt; values <= 1 keep it constant.
+    MaxDelay     time.Duration // Upper bound on the delay; 0 means unbounded.
+    StablePeriod time.Duration // A run lasting this long resets the delay and retry count; 0 never resets.
+
+    // CrashLoopCount consecutive failed runs shorter than CrashLoopThreshold
+    // put the process in StatusCrashLooping; a zero threshold disables this.
+    CrashLoopThreshold time.Duration
+    CrashLoopCount     int
+
+    // OnCodes limits restarts to runs exiting with one of these codes; empty
+    // restarts any failure. A run killed by a signal has no exit code, so it
+    // is only restarted if OnCodes is empty. NotOnCodes are never restarted
+    // and take precedence.
+    OnCodes    []int
+    NotOnCodes []int
+}
+
+// restartsAfter reports whether a failed run that exited with code, -1 if
+// it has none, is restarted.
+func (p RestartPolicy) restartsAfter(code int) bool {
+    if slices.Contains(p.NotOnCodes, code) {
+        return false
+    }
+    return len(p.OnCodes) == 0 || slices.Contains(p.OnCodes, code)
+}
+
+// delayAfter returns the delay following an attempt that waited d.
+func (p RestartPolicy) delayAfter(d time.Duration) time.Duration {
+    if p.Multiplier > 1 {
+        d = time.Duration(float64(d) * p.Multiplier)
+    }
+    if p.MaxDelay > 0 && d > p.MaxDelay {
+        d = p.MaxDelay
+    }
+    return d
+}
+
+// shutdownTimeout bounds how long the manager waits for the HTTP server and
+// the managed processes when shutting down, unless -shutdown-timeout is set.
+const shutdownTimeout = 10 * time.Second
+
+// exitGracePeriod is how long /exit waits for a process to stop before
+// sending SIGKILL when no -stop-timeout is configured.
+const exitGracePeriod = 10 * time.Second
+
+// stopWaitTimeout is how long /stop?wait=true waits for the process to exit
+// when no timeout is given with the request.
+const stopWaitTimeout = 30 * time.Second
+
+// StatusReport is the JSON representation of a process served by /status.
+type StatusReport = api.StatusReport
+
+// ProcessMetrics is a snapshot of the counters and gauges exported on /metrics.
+type ProcessMetrics struct {
+    Labels        map[string]string
+    Status        ProcessStatus
+    Restarts      int
+    Failures      int
+    LinesDropped  uint64 // Log lines missed by streaming clients that fell behind.
+    UptimeSeconds float64
+    ExitCode      *int
+}
+
+// ProcessConfig describes how a managed process is launched and supervised.
+type ProcessConfig struct {
+    Name           string
+    Labels         map[string]string // Metadata echoed in /status and on /metrics.
+    ExecutablePath string
+    Args           []string
+    Dir            string   // Working directory for the process; empty inherits the manager's.
+    Env            []string // Extra KEY=VALUE variables for the process.
+    CleanEnv       bool     // Use only Env instead of extending the manager's environment.
+    ExpandArgs     bool     // Expand $VAR in Args against the process environment at every start.
+    StopSignal     syscall.Signal // Signal sent by Stop; 0 means SIGTERM.
+    StopTimeout    time.Duration  // Grace period before SIGKILL when the manager stops the process itself.
+    MaxRuntime     time.Duration  // Stop the process once it has run this long; 0 means no limit.
+    IdleTimeout    time.Duration  // Stop the process once it has written no output for this long; 0 means never.
+    ProcessGroup   bool           // Run the process in its own group and signal the whole group.
+    Nice           int            // Niceness of the process; 0 keeps the manager's.
+    Umask          int            // File creation mask of the process; negative keeps the manager's.
+    RlimitNofile   uint64         // Limit on open file descriptors of the process; 0 keeps the manager's.
+    RlimitAS       uint64         // Limit in bytes on the address space of the process; 0 keeps the manager's.
+    Credential     *syscall.Credential // User and groups to run the process as; nil keeps the manager's.
+    PreStart       string         // Shell command run to completion before each start; failing aborts the start.
+    PostStop       string         // Shell command run after each exit of the process.
+    DrainSignal    syscall.Signal // Signal sent by Drain; 0 sends none.
+    DrainFile      string         // Marker file created by Drain, relative to Dir; empty creates none.
+    HealthCheck    HealthCheck
+    Restart        RestartPolicy
+    MaxLogBytes    int         // Upper bound on retained output per buffer; 0 keeps everything.
+    MaxLogLine     int         // Lines of output longer than this many bytes are cut short; 0 means no limit.
+    HistorySize    int         // Number of runs kept for /history; 0 uses defaultHistorySize.
+    JSONLogs       bool        // Mirror output to the console as JSON line records.
+    Quiet          bool        // Do not mirror output to the console at all.
+    State          *StateStore   // Optional store persisting every status transition.
+    Webhook        *webhookNotifier // Optional receiver of every status transition.
+    LogFile        *rotatingFile // Optional file receiving a copy of the output.
+}
+
 // ProcessManager holds the state and control for the child process.
 type ProcessManager struct {
     mu             sync.Mutex
+    name           string
+    labels         map[string]string
     executablePath string
     args           []string
-    cmd            *exec.Cmd
+    runArgs        []string // Arguments the current or last run was started with.
+    dir            string
+    env            []string
+    cleanEnv       bool
+    expandArgs     bool
+    stopSignal     syscall.Signal
+    killTimeout    time.Duration
+    maxRuntime     time.Duration
+    idleTimeout    time.Duration
+    processGroup   bool
+    launch         launchAttrs
+    credential     *syscall.Credential
+    preStart       string
+    postStop       string
+    drainSignal    syscall.Signal
+    drainFile      string
+    healthCheck    HealthCheck
+    health         *HealthReport // Latest probe of the current run; nil until the first one.
+    runtimeTimer   *time.Timer
+    timedOut       bool
+    unhealthy      string // Why the run was stopped for failing its health check; empty otherwise.
+    cmd            *exec.Cmd      // The current or last run; nil for a re-attached process.
+    process        *os.Process    // The running process: our child, or one re-attached from the state file.
+    retiring       *retiringRun   // The run a blue/green restart is replacing, until it has exited.
+    ppid           int            // Parent PID of process; 0 if unknown.
+    stdin          io.WriteCloser // Write end of the stdin pipe of the current run; nil for a re-attached process.
+    spool          string         // Base path of the output spools of the current run; empty without a state file.
+    stdinMu        sync.Mutex     // Serializes writes to stdin so request bodies never interleave.
     status         ProcessStatus
-    logBuffer      bytes.Buffer
+    exitCode       *int
+    exitSignal     syscall.Signal // Signal that killed the last run; 0 if it exited normally.
+    startedAt      time.Time
+    exitedAt       time.Time
+    done           chan struct{} // Closed once the current run has exited and its status is recorded.
+    finishing      bool          // The run has exited, but its post-stop hook has not finished; done is still open.
+    starting       chan struct{}      // Closed once the pre-start hook of the start in progress has finished; nil if none is.
+    cancelStart    context.CancelFunc // Kills the pre-start hook of the start in progress.
+    startCancelled bool               // A stop arrived while the pre-start hook ran.
+    logs           *logStore
+    maxLogLine     int
+    jsonLogs       bool
+    quiet          bool
+    state          *StateStore
+    webhook        *webhookNotifier
+    notifiedStatus ProcessStatus // Status last reported to the webhook.
+    logFile        *rotatingFile
+    logBroker      logBroker
+
+    restartPolicy  RestartPolicy
+    retryCount     int
+    restartDelay   time.Duration // Delay before the next automatic restart; 0 until the first one.
+    quickExits     int           // Consecutive failed runs shorter than the crash loop threshold.
+    stopRequested  bool
+    killSent       bool // The manager sent SIGKILL to the current run.
+    termination    api.TerminationReason // How the last run ended; empty while running.
+    restartTimer   *time.Timer
+    restartSeq     int
+    restartsTotal  int
+    failuresTotal  int
+    lastStats      *ProcessStats
+    lastError      string // Why the most recent start or run failed; empty after a clean start.
+    history        []ProcessEvent
+    historySize    int
 }
 
 // NewProcessManager creates and initializes a new manager.
-func NewProcessManager(executablePath string, args []string) *ProcessManager {
+func NewProcessManager(cfg ProcessConfig) *ProcessManager {
+    stopSignal := cfg.StopSignal
+    if stopSignal == 0 {
+        stopSignal = syscall.SIGTERM
+    }
+    killTimeout := cfg.StopTimeout
+    if killTimeout <= 0 {
+        killTimeout = defaultKillTimeout
+    }
+    historySize := cfg.HistorySize
+    if historySize <= 0 {
+        historySize = defaultHistorySize
+    }
     return &ProcessManager{
-        executablePath: executablePath,
-        args:           args,
+        name:           cfg.Name,
+        labels:         cfg.Labels,
+        executablePath: cfg.ExecutablePath,
+        args:           cfg.Args,
+        dir:            cfg.Dir,
+        env:            cfg.Env,
+        cleanEnv:       cfg.CleanEnv,
+        expandArgs:     cfg.ExpandArgs,
+        stopSignal:     stopSignal,
+        killTimeout:    killTimeout,
+        maxRuntime:     cfg.MaxRuntime,
+        idleTimeout:    cfg.IdleTimeout,
+        processGroup:   cfg.ProcessGroup,
+        launch:         newLaunchAttrs(cfg),
+        credential:     cfg.Credential,
+        preStart:       cfg.PreStart,
+        postStop:       cfg.PostStop,
+        drainSignal:    cfg.DrainSignal,
+        drainFile:      cfg.DrainFile,
+        healthCheck:    cfg.HealthCheck,
         status:         StatusNotStarted,
+        logs:           newLogStore(cfg.MaxLogBytes),
+        maxLogLine:     cfg.MaxLogLine,
+        jsonLogs:       cfg.JSONLogs,
+        quiet:          cfg.Quiet,
+        state:          cfg.State,
+        webhook:        cfg.Webhook,
+        notifiedStatus: StatusNotStarted,
+        logFile:        cfg.LogFile,
+        restartPolicy:  cfg.Restart,
+        historySize:    historySize,
     }
 }
 
 // Start launches the executable. It's safe to call on a running process.
+// A manual start cancels any pending automatic restart and resets the retry counter.
+// If the process fails within startGracePeriod, such as a script with a bad
+// interpreter line, the error includes the output it produced.
 func (pm *ProcessManager) Start() error {
+    return pm.StartContext(context.Background())
+}
+
+// StartContext is like Start, but gives up once ctx is done: a cancelled
+// pre-start hook aborts the start, and the start grace period ends early
+// with ctx's error while leaving the started process running.
+func (pm *ProcessManager) StartContext(ctx context.Context) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
     pm.mu.Lock()
-    defer pm.mu.Unlock()
+
+    // Let a run that has exited finish its post-stop hook first, and a
+    // start in progress its pre-start hook.
+    for pm.finishing || pm.starting != nil {
+        done := pm.done
+        queued := pm.starting != nil
+        if queued {
+            done = pm.starting
+        }
+        pm.mu.Unlock()
+        select {
+        case <-done:
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+        pm.mu.Lock()
+        // A stop that cancelled the start in progress came after this
+        // start as well.
+        if queued && pm.startCancelled {
+            pm.mu.Unlock()
+            return ErrStartCancelled
+        }
+    }
 
     // Prevent starting if it's already running.
-    if pm.status == StatusRunning {
-        return fmt.Errorf("process is already running")
+    if pm.aliveLocked() {
+        pm.mu.Unlock()
+        return ErrAlreadyRunning
+    }
+
+    pm.cancelRestartLocked()
+    pm.retryCount = 0
+    pm.restartDelay = 0
+    pm.quickExits = 0
+    if err := pm.startLocked(ctx); err != nil {
+        pm.mu.Unlock()
+        return err
+    }
+    done := pm.done
+    pm.mu.Unlock()
+
+    select {
+    case <-done:
+        return pm.earlyExitError(done)
+    case <-time.After(startGracePeriod):
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// earlyExitError describes the failure of a run that ended during the start
+// grace period, or returns nil if it exited successfully or a later run has
+// already replaced it.
+func (pm *ProcessManager) earlyExitError(done chan struct{}) error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if pm.done != done || (pm.exitCode != nil && *pm.exitCode == 0) {
+        return nil
+    }
+    combined, _, _ := pm.logs.Snapshot(StreamCombined)
+    output := strings.TrimSpace(combined)
+    if len(output) > maxEarlyExitOutput {
+        output = output[len(output)-maxEarlyExitOutput:]
+    }
+    msg := "process exited immediately after starting"
+    if pm.exitCode != nil {
+        msg = fmt.Sprintf("%s with exit code %d", msg, *pm.exitCode)
+    }
+    if output != "" {
+        msg += ": " + output
+    }
+    return errors.New(msg)
+}
+
+// startLocked launches the executable; ctx only bounds the pre-start hook.
+// The caller must hold pm.mu, which is released while the hook runs.
+func (pm *ProcessManager) startLocked(ctx context.Context) error {
+    // The binary may have been replaced or removed since the last start;
+    // the new file is picked up as long as one is in place.
+    if err := checkExecutable(pm.executablePath); err != nil {
+        return pm.failStartLocked(err)
+    }
+    if pm.dir != "" {
+        if info, err := os.Stat(pm.dir); err != nil || !info.IsDir() {
+            return pm.failStartLocked(fmt.Errorf("working directory %s does not exist or is not a directory", pm.dir))
+        }
     }
 
     // exec.Command now includes the arguments.
-    // The '...' unpacks the slice into individual arguments.
-    pm.cmd = exec.Command(pm.executablePath, pm.args...)
-    pm.logBuffer.Reset()
+    // The '...' unpacks the slice into individual arguments, each passed
+    // verbatim as one argv entry without any shell splitting or unquoting;
+    // only $VAR references are expanded, and only if enabled.
+    env := buildEnv(pm.env, pm.cleanEnv)
+    args := pm.args
+    if pm.expandArgs {
+        args = expandArgs(args, env)
+    }
+    cmd := exec.Command(pm.executablePath, args...)
+    cmd.Dir = pm.dir
+    cmd.Env = env
+    cmd.WaitDelay = outputWaitDelay
+    // A group of its own lets signals reach subprocesses the child spawns.
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: pm.processGroup, Credential: pm.credential}
+    // During a blue/green restart the old run is still writing, and its
+    // output is kept; the buffers then hold the output of both.
+    if pm.retiring == nil {
+        pm.logs.Reset()
+    }
+    if path := pm.drainPath(); path != "" {
+        // A marker left by a drained run must not drain the new one.
+        if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+            return pm.failStartLocked(fmt.Errorf("failed to remove drain file: %w", err))
+        }
+    }
+
+    stdout, stderr, lines, err := pm.outputWritersLocked()
+    if err != nil {
+        return pm.failStartLocked(err)
+    }
+    cmd.Stdout = stdout
+    cmd.Stderr = stderr
+    // With a state file the output goes through spool files instead of
+    // pipes, so the process can keep writing if the manager dies.
+    var spools []*outputSpool
+    spool := ""
+    if pm.state != nil {
+        spool = pm.state.spoolBase(pm.name)
+        if spools, err = spoolOutput(cmd, spool, stdout, stderr); err != nil {
+            return pm.failStartLocked(err)
+        }
+    }
+    started := false
+    defer func() {
+        if !started {
+            closeSpools(spools)
+        }
+    }()
+
+    if pm.preStart != "" {
+        if err := pm.runPreStartLocked(ctx, cmd, spools); err != nil {
+            return err
+        }
+    }
 
-    // Capture both stdout and stderr into our log buffer AND the os.Stdout
-    // This allows us to see logs in real-time on the manager's console.
-    multiWriter := io.MultiWriter(&pm.logBuffer, os.Stdout)
-    pm.cmd.Stdout = multiWriter
-    pm.cmd.Stderr = multiWriter
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return pm.failStartLocked(fmt.Errorf("failed to create stdin pipe: %w", err))
+    }
 
     // Start the command asynchronously.
-    if err := pm.cmd.Start(); err != nil {
-        pm.status = StatusFailed
-        return fmt.Errorf("failed to start process: %w", err)
+    if err := startWithAttrs(cmd, pm.launch); err != nil {
+        return pm.failStartLocked(fmt.Errorf("failed to start process: %w", err))
     }
+    started = true
+    pm.cmd = cmd
+    pm.process = cmd.Process
+    pm.ppid = os.Getpid()
+    pm.runArgs = args
+    pm.stdin = stdin
+    pm.spool = spool
 
     pm.status = StatusRunning
-    log.Printf("Started process '%s %v' with PID: %d", pm.executablePath, pm.args, pm.cmd.Process.Pid)
+    pm.exitCode = nil
+    pm.exitSignal = 0
+    pm.startedAt = time.Now()
+    pm.exitedAt = time.Time{}
+    pm.lastStats = nil
+    pm.lastError = ""
+    pm.stopRequested = false
+    pm.killSent = false
+    pm.termination = ""
+    pm.timedOut = false
+    pm.unhealthy = ""
+    pm.health = nil
+    pm.done = make(chan struct{})
+    pm.persistLocked()
+    pm.recordStartLocked(cmd.Process.Pid, pm.startedAt)
+    log.Printf("Started process '%s %v' with PID: %d", pm.executablePath, pm.runArgs, cmd.Process.Pid)
+
+    if pm.maxRuntime > 0 {
+        proc := cmd.Process
+        pm.runtimeTimer = time.AfterFunc(pm.maxRuntime, func() {
+            pm.expire(proc)
+        })
+    }
 
     // Start a goroutine to wait for the process to exit and update the status.
-    go pm.waitForProcess()
+    // It gets its own references so it never touches pm.process or pm.done
+    // outside the lock while a later start reassigns them.
+    go pm.waitForProcess(cmd, pm.postStop, pm.done, spools, lines)
+    if pm.healthCheck.enabled() {
+        go pm.watchHealth(cmd, pm.done)
+    }
+    if pm.idleTimeout > 0 {
+        go pm.watchIdle(pm.done)
+    }
+
+    return nil
+}
+
+// outputWritersLocked returns the writers for the stdout and stderr of a
+// run, along with the line writers whose unterminated last lines must be
+// flushed once its output has ended. The caller must hold pm.mu.
+func (pm *ProcessManager) outputWritersLocked() (stdout, stderr io.Writer, lines []*lineBroadcastWriter, err error) {
+    // Capture stdout and stderr into their own buffers and the combined one,
+    // AND the os.Stdout unless quiet. This allows us to see logs in real-time
+    // on the manager's console.
+    stdoutLines := &lineBroadcastWriter{b: &pm.logBroker}
+    stderrLines := &lineBroadcastWriter{b: &pm.logBroker}
+    stdoutWriters := []io.Writer{pm.logs.Writer(StreamStdout), stdoutLines}
+    stderrWriters := []io.Writer{pm.logs.Writer(StreamStderr), stderrLines}
+    if !pm.quiet {
+        var stdoutMirror, stderrMirror io.Writer = os.Stdout, os.Stdout
+        if pm.jsonLogs {
+            stdoutMirror = &jsonLineWriter{stream: StreamStdout, out: os.Stdout}
+            stderrMirror = &jsonLineWriter{stream: StreamStderr, out: os.Stdout}
+        }
+        stdoutWriters = append(stdoutWriters, &bestEffortWriter{name: "console", w: stdoutMirror})
+        stderrWriters = append(stderrWriters, &bestEffortWriter{name: "console", w: stderrMirror})
+    }
+    if pm.logFile != nil {
+        if err := pm.logFile.Reopen(); err != nil {
+            return nil, nil, nil, err
+        }
+        stdoutWriters = append(stdoutWriters, &bestEffortWriter{name: "log file", w: pm.logFile})
+        stderrWriters = append(stderrWriters, &bestEffortWriter{name: "log file", w: pm.logFile})
+    }
+    stdout = limitLines(io.MultiWriter(stdoutWriters...), pm.maxLogLine)
+    stderr = limitLines(io.MultiWriter(stderrWriters...), pm.maxLogLine)
+    return stdout, stderr, []*lineBroadcastWriter{stdoutLines, stderrLines}, nil
+}
 
+// runPreStartLocked runs the pre-start hook for cmd with pm.mu released, so
+// that status requests, Stop and shutdown are not held up by it. Meanwhile
+// the process is reported as starting, unless a blue/green restart keeps
+// the old run in place; a concurrent start waits for this one, and a stop
+// kills the hook and cancels the start. The output of the hook is drained
+// from spools, if any, before its result is looked at. The caller must hold
+// pm.mu, which is held again on return.
+func (pm *ProcessManager) runPreStartLocked(ctx context.Context, cmd *exec.Cmd, spools []*outputSpool) error {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    starting := make(chan struct{})
+    pm.starting = starting
+    pm.cancelStart = cancel
+    pm.startCancelled = false
+    if pm.retiring == nil {
+        pm.status = StatusStarting
+        pm.persistLocked()
+    }
+    command := pm.preStart
+    pm.mu.Unlock()
+
+    err := runHook(ctx, "pre-start", command, cmd)
+    drainSpools(spools)
+
+    pm.mu.Lock()
+    pm.starting = nil
+    pm.cancelStart = nil
+    close(starting)
+    if pm.startCancelled {
+        if pm.retiring == nil {
+            pm.status = StatusStopped
+            pm.persistLocked()
+        }
+        log.Println("Start cancelled by a stop request.")
+        return ErrStartCancelled
+    }
+    if err != nil {
+        return pm.failStartLocked(fmt.Errorf("%w%s", err, pm.lastStderrLine()))
+    }
     return nil
 }
 
-// waitForProcess blocks until the process exits and then updates its status.
-func (pm *ProcessManager) waitForProcess() {
-    err := pm.cmd.Wait()
+// failStartLocked records a failed start attempt and returns err. The caller
+// must hold pm.mu.
+func (pm *ProcessManager) failStartLocked(err error) error {
+    pm.status = StatusFailed
+    pm.failuresTotal++
+    pm.lastError = err.Error()
+    pm.persistLocked()
+    pm.recordStartFailureLocked()
+    return err
+}
+
+// waitForProcess blocks until cmd exits, updates the status, runs the
+// postStop hook if any and closes done. The status is updated before the
+// hook runs so it never shows a dead process as running; until done is
+// closed the run is finishing, and a new one cannot start. The spools of the
+// run, if any, are closed and unterminated last lines held by its line
+// writers published once output has ended.
+func (pm *ProcessManager) waitForProcess(cmd *exec.Cmd, postStop string, done chan struct{}, spools []*outputSpool, lines []*lineBroadcastWriter) {
+    err := cmd.Wait()
+    drainSpools(spools)
+    if errors.Is(err, exec.ErrWaitDelay) {
+        // The process itself exited successfully.
+        log.Printf("Output of process with PID %d still open %v after it exited; no longer reading it.", cmd.Process.Pid, outputWaitDelay)
+        err = nil
+    }
+    // Taken before the hook adds output of its own.
+    stderrLine := pm.lastStderrLine()
+
+    pm.mu.Lock()
+    // A run a blue/green restart has replaced leaves the status of its
+    // successor alone.
+    retired := pm.done != done
+    if retired {
+        log.Printf("Previous instance with PID %d has exited.", cmd.Process.Pid)
+        pm.recordRetiredExitLocked(cmd.Process.Pid, err)
+    } else {
+        pm.finishing = true
+        pm.recordExitStatusLocked(err, stderrLine)
+    }
+    pm.mu.Unlock()
+
+    if postStop != "" {
+        if hookErr := runHook(context.Background(), "post-stop", postStop, cmd); hookErr != nil {
+            log.Printf("%v", hookErr)
+        }
+    }
+    closeSpools(spools)
+    for _, w := range lines {
+        w.Flush()
+    }
 
     pm.mu.Lock()
     defer pm.mu.Unlock()
+    defer close(done)
+    if retired {
+        return
+    }
+
+    pm.finishing = false
+    // A process stopped on request is not restarted; one the manager
+    // stopped for failing its health check is. A new instance failing
+    // during a blue/green restart leaves the old one in place instead.
+    restart := pm.status == StatusUnhealthy
+    if pm.status == StatusFailed && !pm.stopRequested {
+        code := -1
+        if pm.exitCode != nil {
+            code = *pm.exitCode
+        }
+        restart = pm.restartPolicy.restartsAfter(code)
+        if !restart {
+            log.Printf("Process exited with code %d, which the restart policy does not restart.", code)
+        }
+    }
+    if restart && pm.retiring == nil {
+        pm.scheduleRestartLocked()
+    }
+}
 
+// recordExitStatusLocked records how the current run ended, given the
+// error of waiting for it and the last line it wrote to stderr. The caller
+// must hold pm.mu.
+func (pm *ProcessManager) recordExitStatusLocked(err error, stderrLine string) {
+    pm.exitedAt = time.Now()
+    if pm.runtimeTimer != nil {
+        pm.runtimeTimer.Stop()
+        pm.runtimeTimer = nil
+    }
     if err != nil {
         // An exit code other than 0 is considered an error.
         if exitErr, ok := err.(*exec.ExitError); ok {
             pm.status = StatusFailed
+            code := exitErr.ExitCode()
+            pm.exitCode = &code
+            if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+                pm.exitSignal = ws.Signal()
+            }
             log.Printf("Process exited with error: %v. Exit code: %d", err, exitErr.ExitCode())
         } else {
             pm.status = StatusFailed
@@ -97,150 +694,2213 @@ func (pm *ProcessManager) waitForProcess() {
     } else {
         // Success (exit code 0).
         pm.status = StatusSuccess
+        code := 0
+        pm.exitCode = &code
         log.Println("Process exited successfully.")
     }
+    pm.termination = pm.terminationReasonLocked()
+    switch {
+    case pm.timedOut:
+        pm.status = StatusTimedOut
+    case pm.unhealthy != "":
+        pm.status = StatusUnhealthy
+    case pm.stopRequested && pm.status == StatusFailed:
+        // Dying from the stop signal we sent is an intentional stop, not a failure.
+        pm.status = StatusStopped
+    }
+    if pm.status == StatusFailed || pm.status == StatusTimedOut || pm.status == StatusUnhealthy {
+        pm.failuresTotal++
+        pm.lastError = pm.exitErrorLocked(err, stderrLine)
+    }
+
+    // A run that stayed up long enough ends the current streak of failures.
+    if stable := pm.restartPolicy.StablePeriod; stable > 0 && pm.exitedAt.Sub(pm.startedAt) >= stable {
+        pm.retryCount = 0
+        pm.restartDelay = 0
+    }
+
+    // Restarting a process that keeps dying right away will not help; one
+    // the manager stopped for failing its health check counts as well.
+    failed := pm.status == StatusFailed && !pm.stopRequested || pm.status == StatusUnhealthy
+    if failed && pm.crashLoopingLocked() {
+        pm.status = StatusCrashLooping
+        log.Printf("Process exited within %v of starting %d times in a row; not restarting it.", pm.restartPolicy.CrashLoopThreshold, pm.quickExits)
+    }
+
+    pm.persistLocked()
+    pm.recordExitLocked(pm.process.Pid, pm.exitCode)
 }
 
-// Stop terminates the running process.
-func (pm *ProcessManager) Stop() error {
-    pm.mu.Lock()
-    defer pm.mu.Unlock()
+// crashLoopingLocked counts a failed run towards the crash loop limit if it
+// was shorter than the threshold, and reports whether the limit is reached.
+// The caller must hold pm.mu.
+func (pm *ProcessManager) crashLoopingLocked() bool {
+    threshold := pm.restartPolicy.CrashLoopThreshold
+    if threshold <= 0 {
+        return false
+    }
+    if pm.exitedAt.Sub(pm.startedAt) >= threshold {
+        pm.quickExits = 0
+        return false
+    }
+    pm.quickExits++
+    return pm.quickExits >= pm.restartPolicy.CrashLoopCount
+}
 
-    if pm.status != StatusRunning {
-        return fmt.Errorf("process is not running")
+// exitErrorLocked describes why a run failed: the wait error, followed by
+// stderrLine, the last line the process wrote to stderr, which for a crash
+// on startup is usually the reason. The caller must hold pm.mu.
+func (pm *ProcessManager) exitErrorLocked(err error, stderrLine string) string {
+    switch {
+    case pm.timedOut:
+        return "process exceeded max runtime" + stderrLine
+    case pm.unhealthy != "":
+        return pm.unhealthy + stderrLine
+    default:
+        return err.Error() + stderrLine
     }
+}
 
-    // Send a SIGTERM signal. This is a graceful shutdown signal.
-    if err := pm.cmd.Process.Signal(syscall.SIGTERM); err != nil {
-        return fmt.Errorf("failed to send SIGTERM to process: %w", err)
+// terminationReasonLocked tells how the run that just exited ended. The
+// caller must hold pm.mu.
+func (pm *ProcessManager) terminationReasonLocked() api.TerminationReason {
+    switch {
+    case pm.killSent:
+        return api.TerminationKilled
+    case pm.stopRequested:
+        return api.TerminationStopped
+    case pm.exitSignal != 0:
+        return api.TerminationSignaled
+    default:
+        return api.TerminationExited
     }
+}
 
-    log.Printf("Sent SIGTERM to process with PID: %d", pm.cmd.Process.Pid)
-    return nil
+// lastStderrLine returns the last line written to stderr, prefixed with
+// ": " for appending to an error message, or "" if there is none.
+func (pm *ProcessManager) lastStderrLine() string {
+    stderr, _, _ := pm.logs.Snapshot(StreamStderr)
+    stderr = strings.TrimRight(stderr, "\n")
+    if i := strings.LastIndexByte(stderr, '\n'); i >= 0 {
+        stderr = stderr[i+1:]
+    }
+    if stderr == "" {
+        return ""
+    }
+    return ": " + stderr
 }
 
-// GetStatus returns the current status of the process.
-func (pm *ProcessManager) GetStatus() ProcessStatus {
+// expire stops proc once it has exceeded the maximum runtime, escalating to
+// SIGKILL after the kill timeout. The run is then reported as timed out.
+func (pm *ProcessManager) expire(proc *os.Process) {
     pm.mu.Lock()
     defer pm.mu.Unlock()
-    return pm.status
+
+    if pm.process != proc || !pm.aliveLocked() {
+        return
+    }
+    log.Printf("Process with PID %d exceeded max runtime of %v, stopping it.", proc.Pid, pm.maxRuntime)
+    pm.timedOut = true
+    if err := pm.stopWithTimeoutLocked(pm.stopSignal, pm.killTimeout); err != nil {
+        log.Printf("Failed to stop timed out process: %v", err)
+    }
 }
 
-// GetLogs returns all captured logs from the process.
-func (pm *ProcessManager) GetLogs() string {
+// Reattach adopts a process recorded as running in the state store if it is
+// still alive, so a restarted manager neither orphans nor double-starts it.
+// It reports whether a process was adopted. The output of an adopted process
+// is picked up from its spools where the last manager left off, but its exit
+// status cannot be observed.
+func (pm *ProcessManager) Reattach() bool {
     pm.mu.Lock()
     defer pm.mu.Unlock()
-    return pm.logBuffer.String()
-}
 
-// --- HTTP Handlers ---
+    if pm.state == nil || pm.aliveLocked() {
+        return false
+    }
+    st, ok := pm.state.Get(pm.name)
+    if !ok || (st.Status != StatusRunning && st.Status != StatusDraining) || st.PID <= 0 {
+        return false
+    }
+    if !processAlive(st.PID) || !processRunsExecutable(st.PID, pm.executablePath) {
+        log.Printf("Recorded process with PID %d is gone; reconciling state.", st.PID)
+        if st.Spool != "" {
+            // What it wrote after the last manager died is still kept.
+            spools, lines := pm.resumeSpoolsLocked(st.Spool)
+            closeSpools(spools)
+            for _, w := range lines {
+                w.Flush()
+            }
+            removeSpool(st.Spool + ".stdout")
+            removeSpool(st.Spool + ".stderr")
+        }
+        pm.status = StatusFailed
+        pm.persistLocked()
+        return false
+    }
 
-// makeStatusHandler returns the current process status via API.
-func makeStatusHandler(pm *ProcessManager) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        status := pm.GetStatus()
-        log.Printf("API: /status requested. Current status: %s", status)
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(map[string]string{"status": string(status)})
+    proc, err := os.FindProcess(st.PID)
+    if err != nil {
+        return false
+    }
+    pm.cmd = nil
+    pm.process = proc
+    pm.ppid, _ = readParentPID(st.PID)
+    pm.runArgs = pm.args
+    pm.stdin = nil
+    pm.spool = st.Spool
+    pm.status = st.Status
+    pm.stopRequested = st.Status == StatusDraining
+    if st.StartedAt != nil {
+        pm.startedAt = *st.StartedAt
+    } else {
+        pm.startedAt = time.Now()
+    }
+    pm.exitedAt = time.Time{}
+    pm.lastStats = nil
+    pm.done = make(chan struct{})
+    log.Printf("Re-attached to running process with PID: %d", st.PID)
+
+    pm.persistLocked()
+    pm.recordStartLocked(st.PID, pm.startedAt)
+
+    var spools []*outputSpool
+    var lines []*lineBroadcastWriter
+    if st.Spool != "" {
+        spools, lines = pm.resumeSpoolsLocked(st.Spool)
     }
+    go pm.watchAttached(proc, pm.done, spools, lines)
+    return true
 }
 
-// makeStartHandler starts the process via API.
-func makeStartHandler(pm *ProcessManager) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != http.MethodPost {
-            http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-            return
-        }
+// resumeSpoolsLocked follows the spools at base of a run started by an
+// earlier manager into the output writers, returning them with the line
+// writers to flush once the run has exited. The caller must hold pm.mu.
+func (pm *ProcessManager) resumeSpoolsLocked(base string) ([]*outputSpool, []*lineBroadcastWriter) {
+    stdout, stderr, lines, err := pm.outputWritersLocked()
+    if err != nil {
+        log.Printf("Output of the re-attached process is not available: %v", err)
+        return nil, nil
+    }
+    return resumeSpools(base, stdout, stderr), lines
+}
 
-        err := pm.Start()
-        if err != nil {
-            log.Printf("API: /start failed: %v", err)
-            http.Error(w, err.Error(), http.StatusBadRequest)
-            return
-        }
+// watchAttached polls an adopted process until it disappears, then closes
+// its spools and flushes its line writers. Since it is not our child its exit
+// code is unknown, so unless it was stopped on request the exit is recorded
+// as a failure.
+func (pm *ProcessManager) watchAttached(proc *os.Process, done chan struct{}, spools []*outputSpool, lines []*lineBroadcastWriter) {
+    for processAlive(proc.Pid) {
+        time.Sleep(500 * time.Millisecond)
+    }
+    closeSpools(spools)
+    for _, w := range lines {
+        w.Flush()
+    }
 
-        log.Println("API: /start successful.")
-        w.WriteHeader(http.StatusOK)
-        w.Write([]byte("Process started successfully."))
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    defer close(done)
+
+    pm.exitedAt = time.Now()
+    pm.termination = pm.terminationReasonLocked()
+    if pm.stopRequested {
+        pm.status = StatusStopped
+    } else {
+        pm.status = StatusFailed
+        pm.failuresTotal++
+        pm.lastError = "re-attached process exited; exit status unknown"
+    }
+    log.Printf("Re-attached process with PID %d exited; exit status unknown.", proc.Pid)
+    pm.persistLocked()
+    pm.recordExitLocked(proc.Pid, nil)
+
+    if !pm.stopRequested {
+        pm.scheduleRestartLocked()
     }
 }
 
-// makeStopHandler stops the process via API.
-func makeStopHandler(pm *ProcessManager) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != http.MethodPost {
-            http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-            return
-        }
+// persistLocked records the current state in the state store, if one is
+// configured, and reports a changed status to the webhook. It is called on
+// every status transition. The caller must hold pm.mu.
+func (pm *ProcessManager) persistLocked() {
+    pm.notifyTransitionLocked()
+    if pm.state == nil {
+        return
+    }
 
-        err := pm.Stop()
-        if err != nil {
-            log.Printf("API: /stop failed: %v", err)
-            http.Error(w, err.Error(), http.StatusBadRequest)
-            return
+    st := ProcessState{Status: pm.status, ExitCode: pm.exitCode}
+    if pm.aliveLocked() {
+        startedAt := pm.startedAt
+        st.PID = pm.process.Pid
+        st.StartedAt = &startedAt
+        st.Spool = pm.spool
+    }
+    if err := pm.state.Save(pm.name, st); err != nil {
+        log.Printf("Failed to persist state of %q: %v", pm.name, err)
+    }
+}
+
+// WaitForExit blocks until the current run has exited and its final status
+// has been recorded, or ctx is done. It returns immediately if nothing is running.
+// During a blue/green restart it also waits for the run being replaced.
+func (pm *ProcessManager) WaitForExit(ctx context.Context) error {
+    pm.mu.Lock()
+    if !pm.aliveLocked() && !pm.finishing && pm.retiring == nil {
+        pm.mu.Unlock()
+        return nil
+    }
+    runs := []chan struct{}{pm.done}
+    if pm.retiring != nil {
+        runs = append(runs, pm.retiring.done)
+    }
+    pm.mu.Unlock()
+
+    for _, done := range runs {
+        select {
+        case <-done:
+        case <-ctx.Done():
+            return ctx.Err()
         }
-        log.Println("API: /stop successful.")
-        w.WriteHeader(http.StatusOK)
-        w.Write([]byte("Process stop signal sent."))
     }
+    return nil
 }
 
-func makeExitHandler(pm *ProcessManager) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != http.MethodPost {
-            http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+// scheduleRestartLocked arms a restart if the policy still allows one and
+// reports the process as backing off until then. Each attempt waits longer
+// according to the policy's multiplier. The restart runs on the timer
+// goroutine once the current waitForProcess has returned, so at most one
+// waiter exists at a time. The caller must hold pm.mu.
+func (pm *ProcessManager) scheduleRestartLocked() {
+    if pm.retryCount >= pm.restartPolicy.MaxRetries {
+        return
+    }
+
+    delay := pm.restartDelay
+    if delay == 0 {
+        delay = pm.restartPolicy.Backoff
+    }
+    pm.restartDelay = pm.restartPolicy.delayAfter(delay)
+
+    pm.retryCount++
+    pm.restartSeq++
+    pm.status = StatusBackoff
+    pm.persistLocked()
+    seq := pm.restartSeq
+    log.Printf("Restarting process in %v (attempt %d/%d)", delay, pm.retryCount, pm.restartPolicy.MaxRetries)
+    pm.restartTimer = time.AfterFunc(delay, func() {
+        pm.autoRestart(seq)
+    })
+}
+
+// autoRestart performs a scheduled restart unless it was cancelled in the meantime.
+func (pm *ProcessManager) autoRestart(seq int) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if seq != pm.restartSeq || pm.aliveLocked() || pm.starting != nil {
+        return
+    }
+    pm.restartTimer = nil
+
+    if err := pm.startLocked(context.Background()); err != nil {
+        if errors.Is(err, ErrStartCancelled) {
             return
         }
+        log.Printf("Automatic restart failed: %v", err)
+        pm.scheduleRestartLocked()
+        return
+    }
+    pm.restartsTotal++
+}
 
-        pm.Stop()
-        w.WriteHeader(http.StatusOK)
-        w.Write([]byte("Process stop signal sent."))
-        w.Write([]byte("Exit"))
-        os.Exit(0)
+// cancelRestartLocked stops any pending automatic restart, leaving a
+// backing-off process in the failed state. The caller must hold pm.mu.
+func (pm *ProcessManager) cancelRestartLocked() {
+    pm.restartSeq++
+    if pm.restartTimer != nil {
+        pm.restartTimer.Stop()
+        pm.restartTimer = nil
+    }
+    if pm.status == StatusBackoff {
+        pm.status = StatusFailed
     }
 }
 
-// makeLogHandler returns the process logs via API.
-func makeLogHandler(pm *ProcessManager) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        logs := pm.GetLogs()
-        log.Println("API: /logs requested.")
-        w.Header().Set("Content-Type", "text/plain")
-        w.Write([]byte(logs))
+// Stop terminates the running process.
+func (pm *ProcessManager) Stop() error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    return pm.stopLocked(pm.stopSignal)
+}
+
+// StopContext sends the stop signal and waits for the process to exit. If
+// ctx is done first the process is killed with SIGKILL and ctx's error is
+// returned.
+func (pm *ProcessManager) StopContext(ctx context.Context) error {
+    if err := pm.Stop(); err != nil {
+        return err
+    }
+    if err := pm.WaitForExit(ctx); err != nil {
+        if killErr := pm.ForceKill(); killErr != nil && !errors.Is(killErr, ErrNotRunning) {
+            log.Printf("Failed to kill process that did not stop in time: %v", killErr)
+        }
+        return fmt.Errorf("process did not stop in time: %w", err)
     }
+    return nil
 }
 
-func main() {
-    port := flag.String("port", "8080", "Port for the web server")
-	flag.Parse()
+// StopWithTimeout sends the stop signal and, if the process is still running
+// after the grace period d, escalates to SIGKILL. It returns once the stop
+// signal is sent.
+func (pm *ProcessManager) StopWithTimeout(d time.Duration) error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    return pm.stopWithTimeoutLocked(pm.stopSignal, d)
+}
 
-	args := flag.Args()
-    if len(args) < 1 {
-        log.Fatal("Usage: gowork -port <port> <executable_path> [arg1] [arg2] ...")
+// StopWithSignal stops the process like Stop but with sig instead of the
+// configured stop signal. A positive d escalates to SIGKILL as in StopWithTimeout.
+func (pm *ProcessManager) StopWithSignal(sig syscall.Signal, d time.Duration) error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    if d <= 0 {
+        return pm.stopLocked(sig)
     }
-	executablePath := args[0]
-	executableArgs := args[1:]
+    return pm.stopWithTimeoutLocked(sig, d)
+}
 
-	if _, err := os.Stat(executablePath); os.IsNotExist(err) {
-		log.Fatalf("Executable file not found at: %s", executablePath)
-	}
+// stopWithTimeoutLocked sends sig and arms the SIGKILL escalation after d.
+// The caller must hold pm.mu.
+func (pm *ProcessManager) stopWithTimeoutLocked(sig syscall.Signal, d time.Duration) error {
+    if err := pm.stopLocked(sig); err != nil {
+        return err
+    }
 
-	log.Printf("Managing executable: %s with args: %v", executablePath, executableArgs)
-	manager := NewProcessManager(executablePath, executableArgs)
+    proc := pm.process
+    time.AfterFunc(d, func() {
+        pm.mu.Lock()
+        defer pm.mu.Unlock()
 
-	if err := manager.Start(); err != nil {
-		log.Printf("Initial start failed: %v", err)
-	}
+        // Only kill the process we signalled, and only if it hasn't exited yet.
+        if pm.process != proc || !pm.aliveLocked() {
+            return
+        }
+        if err := pm.signalProcess(proc, syscall.SIGKILL); err != nil {
+            log.Printf("Failed to send SIGKILL to process with PID %d: %v", proc.Pid, err)
+            return
+        }
+        pm.killSent = true
+        log.Printf("Process did not exit within %v, sent SIGKILL to PID: %d", d, proc.Pid)
+    })
+    return nil
+}
 
-	http.HandleFunc("/status", makeStatusHandler(manager))
-	http.HandleFunc("/start", makeStartHandler(manager))
-	http.HandleFunc("/stop", makeStopHandler(manager))
-	http.HandleFunc("/log", makeLogHandler(manager))
-	http.HandleFunc("/exit", makeExitHandler(manager))
+// Restart stops the running process, waits for it to exit and starts it
+// again. A positive stopTimeout escalates to SIGKILL as in StopWithTimeout.
+// If the process is not running it is simply started.
+func (pm *ProcessManager) Restart(ctx context.Context, stopTimeout time.Duration) error {
+    if err := pm.stopAndWait(ctx, stopTimeout); err != nil {
+        return err
+    }
+    if err := pm.Start(); err != nil {
+        return err
+    }
 
-	log.Printf("Starting server on port %s...", *port)
-	if err := http.ListenAndServe(":" + *port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+    pm.mu.Lock()
+    pm.restartsTotal++
+    pm.mu.Unlock()
+    return nil
+}
+
+// RestartWithArgs is like Restart, but replaces the arguments of the process
+// while it is stopped.
+func (pm *ProcessManager) RestartWithArgs(ctx context.Context, stopTimeout time.Duration, args []string) error {
+    return pm.restartWith(ctx, stopTimeout, func() error { return pm.SetArgs(args) })
+}
+
+// RestartAppendingArg is like Restart, but appends arg to the arguments of
+// the process while it is stopped.
+func (pm *ProcessManager) RestartAppendingArg(ctx context.Context, stopTimeout time.Duration, arg string) error {
+    return pm.restartWith(ctx, stopTimeout, func() error { return pm.AppendArg(arg) })
+}
+
+// restartWith is like Restart, but runs update while the process is stopped.
+func (pm *ProcessManager) restartWith(ctx context.Context, stopTimeout time.Duration, update func() error) error {
+    if err := pm.stopAndWait(ctx, stopTimeout); err != nil {
+        return err
+    }
+    if err := update(); err != nil {
+        return err
+    }
+    if err := pm.Start(); err != nil {
+        return err
+    }
+
+    pm.mu.Lock()
+    pm.restartsTotal++
+    pm.mu.Unlock()
+    return nil
+}
+
+// stopAndWait stops the running process and waits for it to exit. It does
+// nothing if the process is not running.
+func (pm *ProcessManager) stopAndWait(ctx context.Context, stopTimeout time.Duration) error {
+    var err error
+    if stopTimeout > 0 {
+        err = pm.StopWithTimeout(stopTimeout)
+    } else {
+        err = pm.Stop()
+    }
+    if err == nil {
+        if err := pm.WaitForExit(ctx); err != nil {
+            return fmt.Errorf("process did not exit: %w", err)
+        }
+    }
+    return nil
+}
+
+// SetArgs replaces the arguments used from the next start on. It fails with
+// ErrAlreadyRunning while a run is in progress.
+func (pm *ProcessManager) SetArgs(args []string) error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if pm.aliveLocked() {
+        return ErrAlreadyRunning
+    }
+    if err := validateArgs(args); err != nil {
+        return err
+    }
+    pm.args = args
+    log.Printf("Updated process arguments to %v", args)
+    return nil
+}
+
+// AppendArg adds arg to the end of the arguments used from the next start
+// on. Like SetArgs it fails with ErrAlreadyRunning while a run is in progress.
+func (pm *ProcessManager) AppendArg(arg string) error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if pm.aliveLocked() {
+        return ErrAlreadyRunning
+    }
+    if err := validateArgs([]string{arg}); err != nil {
+        return err
+    }
+    // A fresh slice, so runArgs of the last run is never modified in place.
+    pm.args = append(append([]string{}, pm.args...), arg)
+    log.Printf("Updated process arguments to %v", pm.args)
+    return nil
+}
+
+// stopLocked sends sig to the running process to stop it. The caller must hold pm.mu.
+// Any pending automatic restart is cancelled as well.
+func (pm *ProcessManager) stopLocked(sig syscall.Signal) error {
+    // Stopping during the pre-start hook cancels the start. During a
+    // blue/green restart the old run is stopped as well.
+    if pm.cancelStart != nil {
+        pm.cancelStart()
+        pm.startCancelled = true
+        if !pm.aliveLocked() {
+            log.Println("Cancelling start in progress.")
+            return nil
+        }
+    }
+
+    // Stopping while backing off just abandons the pending restart.
+    if pm.status == StatusBackoff {
+        pm.status = StatusStopped
+        pm.cancelRestartLocked()
+        pm.persistLocked()
+        log.Println("Cancelled pending restart.")
+        return nil
+    }
+
+    pm.cancelRestartLocked()
+    if !pm.aliveLocked() {
+        return pm.notRunningLocked()
+    }
+
+    pm.stopRequested = true
+
+    // Send the stop signal (SIGTERM by default). This is a graceful shutdown signal.
+    if err := pm.signalProcess(pm.process, sig); err != nil {
+        return fmt.Errorf("failed to send %s to process: %w", signalName(sig), err)
+    }
+
+    log.Printf("Sent %s to process with PID: %d", signalName(sig), pm.process.Pid)
+    return nil
+}
+
+// ForceKill sends SIGKILL to the running process without a grace period.
+func (pm *ProcessManager) ForceKill() error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if pm.cancelStart != nil {
+        pm.cancelStart()
+        pm.startCancelled = true
+        if !pm.aliveLocked() {
+            log.Println("Cancelling start in progress.")
+            return nil
+        }
+    }
+    if !pm.aliveLocked() {
+        return pm.notRunningLocked()
+    }
+
+    pm.cancelRestartLocked()
+    pm.stopRequested = true
+    if err := pm.signalProcess(pm.process, syscall.SIGKILL); err != nil {
+        return fmt.Errorf("failed to send SIGKILL to process: %w", err)
+    }
+    pm.killSent = true
+
+    log.Printf("Sent SIGKILL to process with PID: %d", pm.process.Pid)
+    return nil
+}
+
+// Signal sends an arbitrary signal to the running process.
+func (pm *ProcessManager) Signal(sig syscall.Signal) error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if !pm.aliveLocked() {
+        return pm.notRunningLocked()
+    }
+
+    if err := pm.signalProcess(pm.process, sig); err != nil {
+        return fmt.Errorf("failed to send %s to process: %w", signalName(sig), err)
+    }
+
+    log.Printf("Sent %s to process with PID: %d", signalName(sig), pm.process.Pid)
+    return nil
+}
+
+// WriteStdin copies r to the stdin of the running process. It fails with
+// ErrStdinClosed if the process has closed its stdin or was re-attached, in
+// which case the manager holds no pipe to it.
+func (pm *ProcessManager) WriteStdin(r io.Reader) (int64, error) {
+    pm.mu.Lock()
+    if !pm.aliveLocked() {
+        err := pm.notRunningLocked()
+        pm.mu.Unlock()
+        return 0, err
+    }
+    stdin := pm.stdin
+    pm.mu.Unlock()
+    if stdin == nil {
+        return 0, ErrStdinClosed
+    }
+
+    // The write may block until the process reads, so it must not hold pm.mu.
+    pm.stdinMu.Lock()
+    defer pm.stdinMu.Unlock()
+    n, err := io.Copy(stdin, r)
+    switch {
+    case errors.Is(err, os.ErrClosed):
+        // Wait closes the pipe once the process has exited.
+        return n, ErrNotRunning
+    case errors.Is(err, syscall.EPIPE):
+        return n, ErrStdinClosed
+    case err != nil:
+        return n, fmt.Errorf("failed to write to stdin: %w", err)
+    }
+    return n, nil
+}
+
+// Drain asks the running process to finish its current work and accept no
+// more, by sending the drain signal and/or creating the drain file. The
+// process keeps running in StatusDraining until it exits or is stopped; its
+// exit then counts as a requested stop and is not restarted.
+func (pm *ProcessManager) Drain() error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if pm.status == StatusDraining {
+        return nil
+    }
+    if pm.status != StatusRunning {
+        return pm.notRunningLocked()
+    }
+    if pm.drainSignal == 0 && pm.drainFile == "" {
+        return errors.New("draining is not configured: set a drain signal or drain file")
+    }
+
+    if path := pm.drainPath(); path != "" {
+        if err := os.WriteFile(path, nil, 0o644); err != nil {
+            return fmt.Errorf("failed to create drain file: %w", err)
+        }
+    }
+    if pm.drainSignal != 0 {
+        if err := pm.signalProcess(pm.process, pm.drainSignal); err != nil {
+            return fmt.Errorf("failed to send %s to process: %w", signalName(pm.drainSignal), err)
+        }
+    }
+
+    pm.status = StatusDraining
+    pm.stopRequested = true
+    pm.persistLocked()
+    log.Printf("Draining process with PID: %d", pm.process.Pid)
+    return nil
+}
+
+// drainPath returns the location of the drain file, resolved against the
+// working directory of the process, or "" if none is configured.
+func (pm *ProcessManager) drainPath() string {
+    if pm.drainFile == "" || filepath.IsAbs(pm.drainFile) {
+        return pm.drainFile
+    }
+    return filepath.Join(pm.dir, pm.drainFile)
+}
+
+// notRunningLocked returns the error for an operation that needs a running
+// process: ErrNotStarted before the first start, else ErrNotRunning. The
+// caller must hold pm.mu.
+func (pm *ProcessManager) notRunningLocked() error {
+    if pm.status == StatusNotStarted {
+        return ErrNotStarted
+    }
+    return ErrNotRunning
+}
+
+// aliveLocked reports whether a run is in progress, draining or not. The
+// caller must hold pm.mu.
+func (pm *ProcessManager) aliveLocked() bool {
+    return pm.status == StatusRunning || pm.status == StatusDraining
+}
+
+// signalProcess delivers sig to proc, or to its whole process group when
+// the manager runs children in their own group.
+func (pm *ProcessManager) signalProcess(proc *os.Process, sig syscall.Signal) error {
+    if pm.processGroup {
+        return syscall.Kill(-proc.Pid, sig)
+    }
+    return proc.Signal(sig)
+}
+
+// Owns reports whether pid is the running process, or the one a blue/green
+// restart is replacing.
+func (pm *ProcessManager) Owns(pid int) bool {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    if pm.aliveLocked() && pm.process.Pid == pid {
+        return true
+    }
+    return pm.retiring != nil && pm.retiring.cmd.Process.Pid == pid
+}
+
+// GetStatus returns the current status of the process.
+func (pm *ProcessManager) GetStatus() ProcessStatus {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    return pm.status
+}
+
+// Report returns a snapshot of the process state for the status API.
+// The exit code and run duration are only present once the process has exited.
+func (pm *ProcessManager) Report() StatusReport {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    report := StatusReport{
+        Labels:       pm.labels,
+        Status:       pm.status,
+        ExitCode:     pm.exitCode,
+        Stats:        pm.lastStats,
+        LastError:    pm.lastError,
+        CrashLooping: pm.status == StatusCrashLooping,
+    }
+    report.Executable = pm.executablePath
+    report.Args = pm.args
+    report.TerminationReason = pm.termination
+    report.StderrLines = pm.logs.StderrLines()
+    if pm.retiring != nil {
+        report.PreviousPID = pm.retiring.cmd.Process.Pid
+    }
+    if pm.aliveLocked() {
+        report.PID = pm.process.Pid
+        report.PPID = pm.ppid
+        report.Args = pm.runArgs
+        report.Health = pm.health
+        idle := time.Since(pm.idleSinceLocked()).Seconds()
+        report.IdleSeconds = &idle
+    }
+    if report.Args == nil {
+        report.Args = []string{}
+    }
+    if !pm.startedAt.IsZero() {
+        startedAt := pm.startedAt
+        report.StartedAt = &startedAt
+        if pm.exitedAt.IsZero() {
+            uptime := time.Since(pm.startedAt).Seconds()
+            report.UptimeSeconds = &uptime
+        } else {
+            duration := pm.exitedAt.Sub(pm.startedAt).Seconds()
+            report.RunDurationSeconds = &duration
+        }
+    }
+    return report
+}
+
+// Metrics returns a snapshot of the values exported on /metrics.
+func (pm *ProcessManager) Metrics() ProcessMetrics {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    m := ProcessMetrics{
+        Labels:   pm.labels,
+        Status:   pm.status,
+        Restarts: pm.restartsTotal,
+        Failures: pm.failuresTotal,
+        ExitCode: pm.exitCode,
+    }
+    if pm.aliveLocked() {
+        m.UptimeSeconds = time.Since(pm.startedAt).Seconds()
+    }
+    m.LinesDropped = pm.logBroker.Dropped()
+    return m
+}
+
+// SampleStats reads the current resource usage of the running process and
+// stores it as the latest sample. If the process exits before /proc can be
+// read the sample is discarded and a not-running error is returned.
+func (pm *ProcessManager) SampleStats() (ProcessStats, error) {
+    pm.mu.Lock()
+    if !pm.aliveLocked() {
+        err := pm.notRunningLocked()
+        pm.mu.Unlock()
+        return ProcessStats{}, err
+    }
+    proc := pm.process
+    pm.mu.Unlock()
+
+    stats, err := readProcStats(proc.Pid)
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    if pm.process != proc || !pm.aliveLocked() {
+        return ProcessStats{}, ErrNotRunning
+    }
+    if err != nil {
+        return ProcessStats{}, err
+    }
+    pm.lastStats = &stats
+    return stats, nil
+}
+
+// RetryCount returns the number of automatic restarts since the last manual start.
+func (pm *ProcessManager) RetryCount() int {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    return pm.retryCount
+}
+
+// GetLogs returns all captured logs from the process.
+func (pm *ProcessManager) GetLogs() string {
+    logs, _, _ := pm.logs.Snapshot(StreamCombined)
+    return logs
+}
+
+// LogsTruncated reports whether older log output has been discarded to stay
+// within the configured size limit.
+func (pm *ProcessManager) LogsTruncated() bool {
+    _, truncated, _ := pm.logs.Snapshot(StreamCombined)
+    return truncated
+}
+
+// GetStreamLogs returns the captured output of one stream and whether older
+// output of that stream has been discarded.
+func (pm *ProcessManager) GetStreamLogs(stream LogStream) (string, bool, error) {
+    return pm.logs.Snapshot(stream)
+}
+
+// SubscribeLogs returns a channel receiving each line of log output
+// produced from now on, without its line ending.
+func (pm *ProcessManager) SubscribeLogs() chan string {
+    return pm.logBroker.Subscribe()
+}
+
+// LogTail returns the last n lines of combined output without their line
+// endings.
+func (pm *ProcessManager) LogTail(n int) []string {
+    logs, _, _ := pm.logs.Snapshot(StreamCombined)
+    tail := strings.TrimSuffix(filterLines(logs, nil, n), "\n")
+    if tail == "" {
+        return []string{}
+    }
+    return strings.Split(tail, "\n")
+}
+
+// ClearLogs discards the captured output, so /log only shows what the
+// process writes from now on. A log file and streaming clients are unaffected.
+func (pm *ProcessManager) ClearLogs() {
+    pm.logs.Reset()
+    log.Printf("Cleared captured output of %q.", pm.name)
+}
+
+// SetRestartPolicy replaces the restart policy; a pending restart keeps
+// the delay it was scheduled with.
+func (pm *ProcessManager) SetRestartPolicy(p RestartPolicy) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    pm.restartPolicy = p
+}
+
+// SetMaxLogBytes changes how much output is retained per buffer, dropping
+// the oldest output if more is retained already.
+func (pm *ProcessManager) SetMaxLogBytes(max int) {
+    pm.logs.SetMax(max)
+}
+
+// UnsubscribeLogs stops delivery to a channel returned by SubscribeLogs.
+func (pm *ProcessManager) UnsubscribeLogs(ch chan string) {
+    pm.logBroker.Unsubscribe(ch)
+}
+
+// --- HTTP Handlers ---
+
+// errorStatus maps an error from a ProcessManager operation to an HTTP
+// status: 409 Conflict when the process is in the wrong state for the
+// operation, 400 Bad Request otherwise.
+func errorStatus(err error) int {
+    if errors.Is(err, ErrAlreadyRunning) || errors.Is(err, ErrNotRunning) || errors.Is(err, ErrStdinClosed) || errors.Is(err, ErrRestartInProgress) || errors.Is(err, ErrStartCancelled) {
+        return http.StatusConflict
+    }
+    return http.StatusBadRequest
+}
+
+// errorCode maps an error from a ProcessManager operation to the code
+// reported in the error response.
+func errorCode(err error) string {
+    switch {
+    case errors.Is(err, ErrAlreadyRunning):
+        return api.CodeAlreadyRunning
+    case errors.Is(err, ErrNotStarted):
+        return api.CodeNotStarted
+    case errors.Is(err, ErrNotRunning):
+        return api.CodeNotRunning
+    case errors.Is(err, ErrStdinClosed):
+        return api.CodeStdinClosed
+    case errors.Is(err, ErrRestartInProgress):
+        return api.CodeRestartInProgress
+    case errors.Is(err, ErrStartCancelled):
+        return api.CodeStartCancelled
+    default:
+        return api.CodeBadRequest
+    }
+}
+
+// writeError replies with a JSON error response.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(api.ErrorResponse{Error: msg, Code: code})
+}
+
+// withoutTimeouts lifts the read and write deadlines the server set for the
+// request, for handlers that stream or wait for the process for longer than
+// the server timeouts allow.
+func withoutTimeouts(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        rc := http.NewResponseController(w)
+        if err := rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+            log.Printf("API: clearing read deadline failed: %v", err)
+        }
+        if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+            log.Printf("API: clearing write deadline failed: %v", err)
+        }
+        h(w, r)
+    }
+}
+
+// allowMethod rejects requests using any method but method with 405 Method
+// Not Allowed, naming the accepted methods in the Allow header. GET routes
+// also accept HEAD.
+func allowMethod(method string, h http.HandlerFunc) http.HandlerFunc {
+    allowed := method
+    if method == http.MethodGet {
+        allowed = "GET, HEAD"
+    }
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+            w.Header().Set("Allow", allowed)
+            writeError(w, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Invalid request method")
+            return
+        }
+        h(w, r)
+    }
+}
+
+// writeOpError replies with the error of a failed ProcessManager operation.
+func writeOpError(w http.ResponseWriter, err error) {
+    writeError(w, errorStatus(err), errorCode(err), err.Error())
+}
+
+// makeStatusHandler returns the current process status via API.
+// The optional logtail query parameter includes the last N lines of
+// combined output.
+func makeStatusHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        logTail := 0
+        if v := r.URL.Query().Get("logtail"); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n < 0 {
+                writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid logtail: %s", v))
+                return
+            }
+            logTail = n
+        }
+
+        report := pm.Report()
+        if logTail > 0 {
+            report.LogTail = pm.LogTail(logTail)
+        }
+        log.Printf("API: /status requested. Current status: %s", report.Status)
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(report)
+    }
+}
+
+// makeOwnsHandler tells whether the PID given by the pid query parameter
+// belongs to one of managers and whether it is alive, for external tools
+// double-checking what the manager controls.
+func makeOwnsHandler(managers ...*ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        v := r.URL.Query().Get("pid")
+        pid, err := strconv.Atoi(v)
+        if err != nil || pid <= 0 {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid pid: %q", v))
+            return
+        }
+        answer := api.Ownership{PID: pid, Alive: processAlive(pid)}
+        for _, pm := range managers {
+            if pm.Owns(pid) {
+                answer.Owned = true
+                break
+            }
+        }
+        log.Printf("API: /owns requested for PID %d: owned %t, alive %t.", pid, answer.Owned, answer.Alive)
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(answer)
+    }
+}
+
+// makeHistoryHandler returns the recent runs of the process, oldest first.
+func makeHistoryHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        log.Println("API: /history requested.")
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(pm.History())
+    }
+}
+
+// makeStatsHandler samples and returns the CPU and memory usage of the process.
+func makeStatsHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        stats, err := pm.SampleStats()
+        if err != nil {
+            log.Printf("API: /stats failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(stats)
+    }
+}
+
+// makeVersionHandler reports the build of the manager.
+func makeVersionHandler() http.HandlerFunc {
+    info := buildInfo()
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(info)
+    }
+}
+
+// makeHealthHandler reports that the manager itself is up.
+func makeHealthHandler() http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain")
+        w.Write([]byte("ok"))
+    }
+}
+
+// makeReadyHandler returns 200 only while the process is running, for use
+// as a readiness probe.
+func makeReadyHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain")
+        status := pm.GetStatus()
+        if status != StatusRunning {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+        w.Write([]byte(status))
+    }
+}
+
+// makeStartHandler starts the process via API.
+func makeStartHandler(pm controller) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        err := pm.Start()
+        if err != nil {
+            log.Printf("API: /start failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+
+        log.Println("API: /start successful.")
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Process started successfully."))
+    }
+}
+
+// makeStopHandler stops the process via API. A positive stopTimeout escalates
+// to SIGKILL if the process is still running after that long. The handler
+// returns once the stop signal is sent, unless wait=true is given: then it
+// waits up to timeout (stopWaitTimeout by default) for the process to exit
+// and responds with its final status.
+func makeStopHandler(pm controller, stopTimeout time.Duration) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        wait := false
+        if v := query.Get("wait"); v != "" {
+            b, err := strconv.ParseBool(v)
+            if err != nil {
+                writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid wait: %s", v))
+                return
+            }
+            wait = b
+        }
+        waitTimeout := stopWaitTimeout
+        if v := query.Get("timeout"); v != "" {
+            d, err := time.ParseDuration(v)
+            if err != nil || d <= 0 {
+                writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid timeout: %s", v))
+                return
+            }
+            waitTimeout = d
+        }
+
+        var err error
+        if stopTimeout > 0 {
+            err = pm.StopWithTimeout(stopTimeout)
+        } else {
+            err = pm.Stop()
+        }
+        if err != nil {
+            log.Printf("API: /stop failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+        if wait {
+            ctx, cancel := context.WithTimeout(r.Context(), waitTimeout)
+            defer cancel()
+            if err := pm.WaitForExit(ctx); err != nil {
+                log.Printf("API: /stop: process did not exit within %v.", waitTimeout)
+                writeError(w, http.StatusGatewayTimeout, api.CodeTimeout, fmt.Sprintf("Process did not exit within %v", waitTimeout))
+                return
+            }
+            log.Println("API: /stop successful; process exited.")
+            w.Header().Set("Content-Type", "application/json")
+            json.NewEncoder(w).Encode(statusOf(pm))
+            return
+        }
+        log.Println("API: /stop successful.")
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Process stop signal sent."))
+    }
+}
+
+// makeKillHandler force-kills the process via API.
+func makeKillHandler(pm controller) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if err := pm.ForceKill(); err != nil {
+            log.Printf("API: /kill failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+        log.Println("API: /kill successful.")
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Process killed."))
+    }
+}
+
+// makeSignalHandler sends the signal given by the name query parameter to the process.
+func makeSignalHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        sig, err := parseSignal(r.URL.Query().Get("name"))
+        if err != nil {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+            return
+        }
+
+        if err := pm.Signal(sig); err != nil {
+            log.Printf("API: /signal failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+        log.Printf("API: /signal %s successful.", signalName(sig))
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Signal sent."))
+    }
+}
+
+// makeStdinHandler writes the request body to the stdin of the process.
+func makeStdinHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        n, err := pm.WriteStdin(r.Body)
+        if err != nil {
+            log.Printf("API: /stdin failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+        log.Printf("API: /stdin wrote %d bytes.", n)
+        w.WriteHeader(http.StatusOK)
+        fmt.Fprintf(w, "Wrote %d bytes to stdin.", n)
+    }
+}
+
+// makeDrainHandler puts the process into the draining state via API.
+func makeDrainHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if err := pm.Drain(); err != nil {
+            log.Printf("API: /drain failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+        log.Println("API: /drain successful.")
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Process draining."))
+    }
+}
+
+// makeClearLogsHandler discards the captured process output via API.
+func makeClearLogsHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        pm.ClearLogs()
+        log.Println("API: /log/clear successful.")
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Logs cleared."))
+    }
+}
+
+// makeRestartHandler stops the process, waits for it to exit and starts it again.
+func makeRestartHandler(pm controller, stopTimeout time.Duration) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        restart := pm.Restart
+        switch strategy := r.URL.Query().Get("strategy"); strategy {
+        case "":
+        case "bluegreen":
+            restart = pm.RestartBlueGreen
+        default:
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid strategy: %s (want bluegreen)", strategy))
+            return
+        }
+        if err := restart(r.Context(), stopTimeout); err != nil {
+            log.Printf("API: /restart failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+        log.Println("API: /restart successful.")
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Process restarted successfully."))
+    }
+}
+
+// makeConfigHandler updates the arguments of the process via API. Updates to
+// a running process are rejected unless force is set, which restarts it.
+func makeConfigHandler(pm *ProcessManager, stopTimeout time.Duration) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req api.ConfigUpdate
+        dec := json.NewDecoder(r.Body)
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&req); err != nil {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid config: %v", err))
+            return
+        }
+        if req.Args == nil {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, "Invalid config: args is required")
+            return
+        }
+        // Checked up front so a forced update never stops the process for nothing.
+        if err := validateArgs(*req.Args); err != nil {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid config: %v", err))
+            return
+        }
+
+        var err error
+        if req.Force {
+            err = pm.RestartWithArgs(r.Context(), stopTimeout, *req.Args)
+        } else {
+            err = pm.SetArgs(*req.Args)
+        }
+        if err != nil {
+            log.Printf("API: /config failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+        log.Println("API: /config successful.")
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Process arguments updated."))
+    }
+}
+
+// makeAppendArgHandler appends one argument for the next start. Like
+// /config it refuses to touch a running process unless force restarts it.
+func makeAppendArgHandler(pm *ProcessManager, stopTimeout time.Duration) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req api.ArgAppend
+        dec := json.NewDecoder(r.Body)
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&req); err != nil {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid request: %v", err))
+            return
+        }
+        if req.Arg == nil {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, "Invalid request: arg is required")
+            return
+        }
+        if err := validateArgs([]string{*req.Arg}); err != nil {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid request: %v", err))
+            return
+        }
+
+        var err error
+        if req.Force {
+            err = pm.RestartAppendingArg(r.Context(), stopTimeout, *req.Arg)
+        } else {
+            err = pm.AppendArg(*req.Arg)
+        }
+        if err != nil {
+            log.Printf("API: /config/args/append failed: %v", err)
+            writeOpError(w, err)
+            return
+        }
+        log.Println("API: /config/args/append successful.")
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Process argument appended."))
+    }
+}
+
+// makeExitHandler stops every managed process, waits for them to exit and
+// exits the manager. A process still running after stopTimeout (or
+// exitGracePeriod if unset) is killed with SIGKILL.
+func makeExitHandler(reg *Registry, stopTimeout time.Duration) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        grace := stopTimeout
+        if grace <= 0 {
+            grace = exitGracePeriod
+        }
+        var stopping []*ProcessManager
+        reg.Each(func(name string, pm *ProcessManager) {
+            pm.StopWithTimeout(grace)
+            stopping = append(stopping, pm)
+        })
+
+        // Give SIGKILL a moment to take effect after the grace period.
+        ctx, cancel := context.WithTimeout(context.Background(), grace+shutdownTimeout)
+        defer cancel()
+        report := api.ExitReport{Stopped: []string{}, Unstopped: []string{}}
+        for _, pm := range stopping {
+            if err := pm.WaitForExit(ctx); err != nil {
+                log.Printf("Process %q did not exit before /exit: %v", pm.name, err)
+                report.Unstopped = append(report.Unstopped, pm.name)
+                continue
+            }
+            report.Stopped = append(report.Stopped, pm.name)
+        }
+
+        log.Println("API: /exit successful.")
+        // With a known length the body is complete once flushed; a chunked
+        // body would lack its final chunk when the manager exits.
+        body, _ := json.Marshal(report)
+        body = append(body, '\n')
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+        w.Header().Set("Connection", "close")
+        w.Write(body)
+        if f, ok := w.(http.Flusher); ok {
+            f.Flush()
+        }
+        exit(0)
+    }
+}
+
+// makeLogHandler returns the process logs via API. The optional stream query
+// parameter selects stdout or stderr instead of the combined output; grep
+// keeps only lines matching a regular expression and tail only the last N
+// lines. With follow=true the connection stays open after the retained
+// output and new lines of combined output are streamed as they are written,
+// like tail -f, until the client disconnects.
+func makeLogHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        stream := LogStream(query.Get("stream"))
+        if stream == "" {
+            stream = StreamCombined
+        }
+        var re *regexp.Regexp
+        if pattern := query.Get("grep"); pattern != "" {
+            var err error
+            if re, err = regexp.Compile(pattern); err != nil {
+                writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid grep pattern: %v", err))
+                return
+            }
+        }
+        tail := 0
+        if v := query.Get("tail"); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n < 0 {
+                writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid tail: %s", v))
+                return
+            }
+            tail = n
+        }
+        if query.Has("since") {
+            // Output is retained as raw bytes, without per-line timestamps.
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, "since is not supported: log lines are not timestamped")
+            return
+        }
+        follow := false
+        if v := query.Get("follow"); v != "" {
+            b, err := strconv.ParseBool(v)
+            if err != nil {
+                writeError(w, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("Invalid follow: %s", v))
+                return
+            }
+            follow = b
+        }
+
+        var lines chan string
+        var flusher http.Flusher
+        if follow {
+            // New lines are only published for the combined output.
+            if stream != StreamCombined {
+                writeError(w, http.StatusBadRequest, api.CodeBadRequest, "follow is only supported for the combined stream")
+                return
+            }
+            var ok bool
+            if flusher, ok = w.(http.Flusher); !ok {
+                writeError(w, http.StatusInternalServerError, api.CodeInternal, "Streaming not supported")
+                return
+            }
+            // Subscribe before taking the snapshot so no line falls between the two.
+            lines = pm.SubscribeLogs()
+            defer pm.UnsubscribeLogs(lines)
+        }
+
+        logs, truncated, err := pm.GetStreamLogs(stream)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+            return
+        }
+        if re != nil || tail > 0 {
+            logs = filterLines(logs, re, tail)
+        }
+        log.Printf("API: /logs requested (stream: %s).", stream)
+        w.Header().Set("Content-Type", "text/plain")
+        // Tells empty output of a process that has not started yet apart
+        // from a process that printed nothing.
+        w.Header().Set("X-Process-Status", string(pm.GetStatus()))
+        if truncated {
+            w.Header().Set("X-Log-Truncated", "true")
+        }
+        w.Header().Add("Vary", "Accept-Encoding")
+        var out io.Writer = w
+        var gz *gzip.Writer
+        if acceptsGzip(r) {
+            w.Header().Set("Content-Encoding", "gzip")
+            gz = gzip.NewWriter(w)
+            defer gz.Close()
+            out = gz
+        }
+        if !follow {
+            out.Write([]byte(logs))
+            return
+        }
+
+        // Flushing the compressor first gets every line to the client as it
+        // arrives rather than once a block is full.
+        flush := func() {
+            if gz != nil {
+                gz.Flush()
+            }
+            flusher.Flush()
+        }
+        w.Header().Set("Cache-Control", "no-cache")
+        w.Header().Set("X-Content-Type-Options", "nosniff")
+        if _, err := out.Write([]byte(logs)); err != nil {
+            return
+        }
+        flush()
+        for {
+            select {
+            case <-r.Context().Done():
+                log.Println("API: /log follow client disconnected.")
+                return
+            case line, ok := <-lines:
+                if !ok {
+                    return
+                }
+                if re != nil && !re.MatchString(line) {
+                    continue
+                }
+                if _, err := io.WriteString(out, line+"\n"); err != nil {
+                    return
+                }
+                flush()
+            }
+        }
+    }
+}
+
+// makeStderrHandler serves only the error output of the process; it takes
+// the same grep and tail parameters as /log.
+func makeStderrHandler(pm *ProcessManager) http.HandlerFunc {
+    logs := makeLogHandler(pm)
+    return func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        if query.Has("stream") || query.Has("follow") {
+            writeError(w, http.StatusBadRequest, api.CodeBadRequest, "stream and follow are not supported; use /log")
+            return
+        }
+        query.Set("stream", string(StreamStderr))
+        r = r.Clone(r.Context())
+        r.URL.RawQuery = query.Encode()
+        logs(w, r)
+    }
+}
+
+// makeLogStreamHandler streams new log lines as Server-Sent Events.
+func makeLogStreamHandler(pm *ProcessManager) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        flusher, ok := w.(http.Flusher)
+        if !ok {
+            writeError(w, http.StatusInternalServerError, api.CodeInternal, "Streaming not supported")
+            return
+        }
+
+        ch := pm.SubscribeLogs()
+        defer pm.UnsubscribeLogs(ch)
+        log.Println("API: /log/stream client connected.")
+
+        w.Header().Set("Content-Type", "text/event-stream")
+        w.Header().Set("Cache-Control", "no-cache")
+        w.Header().Set("Connection", "keep-alive")
+        w.WriteHeader(http.StatusOK)
+        flusher.Flush()
+
+        for {
+            select {
+            case <-r.Context().Done():
+                log.Println("API: /log/stream client disconnected.")
+                return
+            case line := <-ch:
+                fmt.Fprintf(w, "data: %s\n\n", line)
+                flusher.Flush()
+            }
+        }
+    }
+}
+
+// makeProcessesHandler lists every managed process with its status.
+func makeProcessesHandler(reg *Registry) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        processes := []api.ProcessInfo{}
+        reg.Each(func(name string, pm *ProcessManager) {
+            processes = append(processes, api.ProcessInfo{ID: name, Status: pm.GetStatus()})
+        })
+        log.Println("API: /processes requested.")
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(processes)
+    }
+}
+
+// withProcess serves a request with the handler built for the process named
+// by the {id} path segment, or 404 if no such process is registered.
+func withProcess(reg *Registry, makeHandler func(*ProcessManager) http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id := r.PathValue("id")
+        pm, ok := reg.Get(id)
+        if !ok {
+            writeError(w, http.StatusNotFound, api.CodeNotFound, fmt.Sprintf("Unknown process: %s", id))
+            return
+        }
+        makeHandler(pm)(w, r)
+    }
+}
+
+// processRoute is a per-process endpoint, served unprefixed for the primary
+// process and under /process/{id}/ for every registered one. With replicas,
+// routes that have a makeGroupHandler act on all of them when unprefixed.
+type processRoute struct {
+    path             string
+    control          bool   // Control routes change the process state.
+    method           string // Defaults to POST for control routes and GET otherwise.
+    long             bool   // May stream or wait for the process, so the server timeouts do not apply.
+    makeHandler      func(*ProcessManager) http.HandlerFunc
+    makeGroupHandler func(*replicaGroup) http.HandlerFunc
+}
+
+func main() {
+    configFile := flag.String("config", "", "JSON config file; flags given on the command line override its values")
+    dryRunFlag := flag.Bool("dry-run", false, "Validate the configuration and exit without starting any process or the server")
+    port := flag.String("port", "8080", "Port for the web server")
+    adminAddr := flag.String("admin-addr", "", "Address such as 127.0.0.1:9090 of a separate listener for the control endpoints; the main one then only serves read-only endpoints")
+    socketPath := flag.String("socket", "", "Serve the API on a Unix socket at this path instead of a TCP port")
+    httpReadTimeout := flag.Duration("http-read-timeout", 30*time.Second, "Maximum time to read an API request, including its body (0 means no limit)")
+    httpWriteTimeout := flag.Duration("http-write-timeout", 30*time.Second, "Maximum time to write an API response; streams and requests waiting for the process are exempt (0 means no limit)")
+    httpIdleTimeout := flag.Duration("http-idle-timeout", 2*time.Minute, "Maximum time an idle keep-alive connection to the API stays open (0 uses -http-read-timeout)")
+    portRetry := flag.Duration("port-retry", 0, "Keep retrying for this long if the port is in use (0 fails immediately)")
+    portFallback := flag.Bool("port-fallback", false, "Use the next free port if the port is in use")
+    processName := flag.String("name", "", "Name of the process given on the command line (defaults to the executable's base name)")
+    replicas := flag.Int("replicas", 1, "Number of instances of the process given on the command line, named <name>-<index>")
+    workdir := flag.String("workdir", "", "Working directory for the process given on the command line")
+    var envVars stringList
+    flag.Var(&envVars, "env", "Environment variable KEY=VALUE for the process given on the command line (repeatable)")
+    envFile := flag.String("env-file", "", "File of KEY=VALUE lines added to the environment of the process given on the command line")
+    shell := flag.Bool("shell", false, "Run the command line given on the command line with sh -c, so pipes and redirects work; never pass untrusted input with it")
+    expandArgsFlag := flag.Bool("expand-args", false, "Expand $VAR references in the arguments of the process given on the command line against its environment")
+    envClean := flag.Bool("env-clean", false, "Do not inherit the manager's environment; pass only the explicitly provided variables")
+    processesFile := flag.String("processes", "", "JSON file with additional process definitions (name, path, args)")
+    maxRetries := flag.Int("max-retries", 0, "Maximum automatic restarts after a failure (0 disables restarts)")
+    restartBackoff := flag.Duration("restart-backoff", time.Second, "Delay before the first automatic restart")
+    backoffMultiplier := flag.Float64("restart-backoff-multiplier", 1, "Factor by which the restart delay grows after each attempt")
+    backoffMax := flag.Duration("restart-backoff-max", 0, "Maximum delay between automatic restarts (0 is unbounded)")
+    stablePeriod := flag.Duration("restart-stable-period", 0, "Run time after which the restart delay and retry count reset (0 never resets)")
+    crashLoopThreshold := flag.Duration("crash-loop-threshold", 0, "Runs failing sooner than this count towards crash loop detection (0 disables it)")
+    crashLoopCount := flag.Int("crash-loop-count", 3, "Consecutive quick failures after which the process is reported as crash looping and no longer restarted")
+    var restartOnCodes, noRestartOnCodes exitCodeList
+    flag.Var(&restartOnCodes, "restart-on-codes", "Comma-separated exit codes such as 1,2 that are restarted; unset restarts any failure")
+    flag.Var(&noRestartOnCodes, "no-restart-on-codes", "Comma-separated exit codes that are never restarted, even if -restart-on-codes lists them")
+    historySize := flag.Int("history-size", defaultHistorySize, "Number of past runs of each process kept for /history")
+    logMaxBytes := flag.Int("log-max-bytes", 10<<20, "Maximum bytes of process output kept in memory (0 is unbounded)")
+    logMaxLine := flag.Int("log-max-line", 0, "Cut lines of process output longer than this many bytes, marking them as truncated (0 is unbounded)")
+    authToken := flag.String("auth-token", "", "Bearer token required by the control endpoints (empty disables authentication)")
+    authRead := flag.Bool("auth-read", false, "Also require the bearer token on read-only endpoints")
+    var labelFlags stringList
+    flag.Var(&labelFlags, "label", "Label KEY=VALUE of the process given on the command line, shown in /status and on /metrics (repeatable)")
+    var allowIPs, trustedProxies stringList
+    flag.Var(&allowIPs, "allow-ip", "IP address or CIDR range allowed to call the control endpoints (repeatable; default allows all)")
+    controlRate := flag.Float64("control-rate", 0, "Maximum average rate of control requests per second, shared by all control endpoints (0 is unlimited)")
+    controlBurst := flag.Int("control-burst", 5, "Number of control requests allowed in a burst above -control-rate")
+    flag.Var(&trustedProxies, "trusted-proxy", "IP address or CIDR range of a proxy whose X-Forwarded-For header is trusted (repeatable)")
+    logFile := flag.String("log-file", "", "File receiving a copy of the output of the process given on the command line")
+    logMaxSize := flag.Int("log-max-size", 100, "Size in megabytes at which the log file is rotated (0 disables rotation)")
+    logMaxBackups := flag.Int("log-max-backups", 0, "Number of rotated log files to keep (0 keeps all)")
+    logMaxAge := flag.Duration("log-max-age", 0, "Maximum age of rotated log files (0 keeps them regardless of age)")
+    logJSON := flag.Bool("log-json", false, "Mirror process output to the console as JSON records {stream, timestamp, line}")
+    quiet := flag.Bool("quiet", false, "Do not mirror process output to the manager's stdout; it is still captured and written to any log file")
+    logFormat := flag.String("log-format", "text", "Format of the manager's own log messages: text or json")
+    webhookURL := flag.String("webhook-url", "", "URL receiving a JSON POST on every status transition of a process")
+    reexecOnChange := flag.Bool("reexec-on-config-change", false, "When a SIGHUP reload finds -config changes that need a manager restart, such as the port, stop the processes and re-exec the manager with the same arguments")
+    stateFile := flag.String("state-file", "", "File persisting process state so a restarted manager can re-attach to running processes; their output then goes through spool files next to it")
+    tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS together with -tls-key")
+    tlsKey := flag.String("tls-key", "", "TLS private key file")
+    tlsClientCA := flag.String("tls-client-ca", "", "CA bundle for verifying client certificates (enables mutual TLS)")
+    shutdownTimeoutFlag := flag.Duration("shutdown-timeout", shutdownTimeout, "Deadline for stopping the processes and the HTTP server on SIGINT/SIGTERM, after which processes are killed and the manager exits non-zero")
+    stopTimeout := flag.Duration("stop-timeout", 0, "Grace period after the stop signal before sending SIGKILL (0 never escalates)")
+    idleTimeout := flag.Duration("idle-timeout", 0, "Stop the process once it has written no output for this long (0 never stops it)")
+    maxRuntime := flag.Duration("max-runtime", 0, "Stop the process once it has run this long and report it as timed out (0 means no limit)")
+    var watchPaths stringList
+    flag.Var(&watchPaths, "watch", "File or directory whose changes restart the running processes (repeatable)")
+    watchDebounce := flag.Duration("watch-debounce", 500*time.Millisecond, "How long watched files must stay unchanged before the processes are restarted")
+    waitForExec := flag.Duration("wait-for-exec", 0, "How long to wait for a missing executable to appear before giving up (0 fails at once)")
+    waitMode := flag.Bool("wait", false, "Run the single process to completion without the HTTP server and exit with its exit status")
+    noAutostart := flag.Bool("no-autostart", false, "Do not start the processes on boot; they stay not_started until /start is called")
+    processGroup := flag.Bool("process-group", false, "Run each process in its own process group and signal the whole group")
+    nice := flag.Int("nice", 0, "Niceness of the processes, from -20 (highest priority) to 19 (0 keeps the manager's)")
+    userName := flag.String("user", "", "User, by name or ID, to run the processes as; requires running the manager as root")
+    groupName := flag.String("group", "", "Group, by name or ID, to run the processes as (defaults to the primary group of -user)")
+    rlimitNofile := flag.Uint64("rlimit-nofile", 0, "Maximum number of open files of the processes (0 keeps the manager's limit)")
+    rlimitAS := flag.Uint64("rlimit-as", 0, "Maximum address space of the processes in bytes (0 keeps the manager's limit)")
+    umaskFlag := flag.String("umask", "", "Octal file creation mask of the processes, such as 022 (empty keeps the manager's)")
+    preStart := flag.String("pre-start", "", "Shell command run before each start of the process given on the command line; a failure aborts the start")
+    postStop := flag.String("post-stop", "", "Shell command run after each exit of the process given on the command line")
+    drainSignalName := flag.String("drain-signal", "", "Signal sent by /drain to make a process stop taking new work")
+    healthCmd := flag.String("health-cmd", "", "Shell command probing the health of the process given on the command line; a non-zero exit is a failure")
+    healthURL := flag.String("health-url", "", "URL probed with GET for the health of the process given on the command line; a non-2xx answer is a failure")
+    healthInterval := flag.Duration("health-interval", 10*time.Second, "Time between health probes")
+    healthTimeout := flag.Duration("health-timeout", 5*time.Second, "Time after which a health probe fails (0 means no limit)")
+    startTimeout := flag.Duration("start-timeout", 0, "Stop the process and report it unhealthy if it does not pass a health check within this long after starting; restarted per -max-retries (0 means no limit)")
+    healthRetries := flag.Int("health-retries", 3, "Consecutive failed health probes after which the process is stopped as unhealthy and restarted per -max-retries (0 never stops it)")
+    drainFile := flag.String("drain-file", "", "Marker file created by /drain, relative to each process's working directory")
+    stopSignalName := flag.String("stop-signal", "SIGTERM", "Signal sent to stop the process gracefully")
+	flag.Parse()
+
+	info := buildInfo()
+	log.Printf("gowork %s (commit %s, built %s)", info.Version, info.Commit, info.BuildDate)
+
+	args := flag.Args()
+	if *reexecOnChange && *configFile == "" {
+		log.Fatal("-reexec-on-config-change requires -config")
+	}
+	var reloader *configReloader
+	if *configFile != "" {
+		cfg, err := loadFileConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reloader = newConfigReloader(*configFile, cfg, flag.CommandLine)
+		if err := cfg.apply(flag.CommandLine); err != nil {
+			log.Fatal(err)
+		}
+		if len(args) == 0 && cfg.Executable != "" {
+			args = append([]string{cfg.Executable}, cfg.Args...)
+		}
+	}
+	if err := setLogFormat(*logFormat); err != nil {
+		log.Fatal(err)
+	}
+
+	useTLS := *tlsCert != "" || *tlsKey != ""
+	if useTLS && (*tlsCert == "" || *tlsKey == "") {
+		log.Fatal("Both -tls-cert and -tls-key are required to enable TLS")
+	}
+	if *tlsClientCA != "" && !useTLS {
+		log.Fatal("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+
+	allowed, err := parsePrefixes(allowIPs)
+	if err != nil {
+		log.Fatalf("Invalid -allow-ip: %v", err)
+	}
+	if len(allowed) > 0 && *socketPath != "" {
+		// Clients of a Unix socket have no IP address; file permissions control access.
+		log.Fatal("-allow-ip cannot be combined with -socket")
+	}
+	proxies, err := parsePrefixes(trustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid -trusted-proxy: %v", err)
+	}
+
+	stopSignal, err := parseSignal(*stopSignalName)
+	if err != nil {
+		log.Fatalf("Invalid -stop-signal: %v", err)
+	}
+	var drainSignal syscall.Signal
+	if *drainSignalName != "" {
+		drainSignal, err = parseSignal(*drainSignalName)
+		if err != nil {
+			log.Fatalf("Invalid -drain-signal: %v", err)
+		}
+	}
+	credential, err := resolveCredential(*userName, *groupName)
+	if err != nil {
+		log.Fatalf("Invalid -user/-group: %v", err)
+	}
+	umask := -1
+	if *umaskFlag != "" {
+		umask, err = parseUmask(*umaskFlag)
+		if err != nil {
+			log.Fatalf("Invalid -umask: %v", err)
+		}
+	}
+
+    if len(args) < 1 && *processesFile == "" {
+        log.Fatal("Usage: gowork [-config <file>] -port <port> [-processes <file>] [<executable_path> [arg1] [arg2] ...]")
+    }
+
+	var defs []ProcessDefinition
+	var group *replicaGroup
+	if *replicas < 1 {
+		log.Fatal("-replicas must be at least 1")
+	}
+	if *healthCmd != "" && *healthURL != "" {
+		log.Fatal("-health-cmd and -health-url are mutually exclusive")
+	}
+	if *healthInterval <= 0 {
+		log.Fatal("-health-interval must be positive")
+	}
+	if len(args) > 0 {
+		name := *processName
+		if name == "" {
+			name = filepath.Base(args[0])
+			// With -shell, args[0] may hold a whole command line.
+			if fields := strings.Fields(args[0]); *shell && len(fields) > 0 {
+				name = filepath.Base(fields[0])
+			}
+		}
+		labels, err := parseLabels(labelFlags)
+		if err != nil {
+			log.Fatalf("Invalid -label: %v", err)
+		}
+		var env []string
+		if *envFile != "" {
+			fileEnv, err := loadEnvFile(*envFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			env = append(env, fileEnv...)
+		}
+		env = append(env, envVars...)
+		def := ProcessDefinition{
+			Name:       name,
+			Path:       args[0],
+			Args:       args[1:],
+			Workdir:    *workdir,
+			Env:        env,
+			EnvClean:   *envClean,
+			ExpandArgs: *expandArgsFlag,
+			Shell:      *shell,
+			LogFile:    *logFile,
+			PreStart:   *preStart,
+			PostStop:   *postStop,
+			HealthCmd:  *healthCmd,
+			HealthURL:  *healthURL,
+			Labels:     labels,
+		}
+		if *replicas > 1 {
+			group = &replicaGroup{name: name}
+			for _, d := range replicaDefinitions(def, *replicas) {
+				group.ids = append(group.ids, d.Name)
+				defs = append(defs, d)
+			}
+		} else {
+			defs = append(defs, def)
+		}
+	}
+	if *processesFile != "" {
+		fileDefs, err := loadProcessDefinitions(*processesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defs = append(defs, fileDefs...)
+	}
+
+	for i := range defs {
+		if defs[i].Shell {
+			defs[i] = defs[i].viaShell()
+		}
+	}
+
+	if *dryRunFlag {
+		if err := dryRun(defs, *port); err != nil {
+			log.Fatalf("Dry run failed:\n%v", err)
+		}
+		log.Println("Dry run passed: configuration is valid.")
+		return
+	}
+
+	var state *StateStore
+	if *stateFile != "" {
+		state, err = OpenStateStore(*stateFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Created even without a URL, since a config reload may set one.
+	webhook := newWebhookNotifier(*webhookURL)
+	restartPolicy := func() RestartPolicy {
+		return RestartPolicy{
+			MaxRetries:         *maxRetries,
+			Backoff:            *restartBackoff,
+			Multiplier:         *backoffMultiplier,
+			MaxDelay:           *backoffMax,
+			StablePeriod:       *stablePeriod,
+			CrashLoopThreshold: *crashLoopThreshold,
+			CrashLoopCount:     *crashLoopCount,
+			OnCodes:            restartOnCodes,
+			NotOnCodes:         noRestartOnCodes,
+		}
+	}
+
+	registry := NewRegistry()
+	execDeadline := time.Now().Add(*waitForExec)
+	logFiles := make(map[string]*rotatingFile)
+	for _, def := range defs {
+		if err := validateEnv(def.Env); err != nil {
+			log.Fatalf("Process %q: %v", def.Name, err)
+		}
+		if err := validateArgs(def.Args); err != nil {
+			log.Fatalf("Process %q: %v", def.Name, err)
+		}
+		if err := validateLabels(def.Labels); err != nil {
+			log.Fatalf("Process %q: %v", def.Name, err)
+		}
+		path, err := waitForExecutable(def.Path, execDeadline)
+		if err != nil {
+			log.Fatalf("Process %q: %v", def.Name, err)
+		}
+		def.Path = path
+
+		// Replicas, and any processes configured with the same path, share
+		// one writer so rotation of the file is coordinated.
+		var rotating *rotatingFile
+		if def.LogFile != "" {
+			key := filepath.Clean(def.LogFile)
+			if rotating = logFiles[key]; rotating == nil {
+				rotating = newRotatingFile(def.LogFile, int64(*logMaxSize)<<20, *logMaxBackups, *logMaxAge)
+				logFiles[key] = rotating
+				defer rotating.Close()
+			}
+		}
+
+		log.Printf("Managing executable %q: %s with args: %v", def.Name, def.Path, def.Args)
+		manager := NewProcessManager(ProcessConfig{
+			Name:           def.Name,
+			Labels:         def.Labels,
+			ExecutablePath: def.Path,
+			Args:           def.Args,
+			Dir:            def.Workdir,
+			Env:            def.Env,
+			CleanEnv:       def.EnvClean,
+			ExpandArgs:     def.ExpandArgs,
+			StopSignal:     stopSignal,
+			StopTimeout:    *stopTimeout,
+			MaxRuntime:     *maxRuntime,
+			IdleTimeout:    *idleTimeout,
+			ProcessGroup:   *processGroup,
+			Nice:           *nice,
+			Umask:          umask,
+			RlimitNofile:   *rlimitNofile,
+			RlimitAS:       *rlimitAS,
+			Credential:     credential,
+			PreStart:       def.PreStart,
+			PostStop:       def.PostStop,
+			DrainSignal:    drainSignal,
+			DrainFile:      *drainFile,
+			HealthCheck: HealthCheck{
+				Command:  def.HealthCmd,
+				URL:      def.HealthURL,
+				Interval: *healthInterval,
+				Timeout:  *healthTimeout,
+				Retries:  *healthRetries,
+
+				StartTimeout: *startTimeout,
+			},
+			Restart:     restartPolicy(),
+			MaxLogBytes: *logMaxBytes,
+			MaxLogLine:  *logMaxLine,
+			HistorySize: *historySize,
+			JSONLogs:    *logJSON,
+			Quiet:       *quiet,
+			State:       state,
+			Webhook:     webhook,
+			LogFile:     rotating,
+		})
+		if err := registry.Register(def.Name, manager); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if reloader != nil {
+		reloader.apply = func() {
+			policy := restartPolicy()
+			registry.Each(func(name string, manager *ProcessManager) {
+				manager.SetRestartPolicy(policy)
+				manager.SetMaxLogBytes(*logMaxBytes)
+			})
+			webhook.SetURL(*webhookURL)
+		}
+	}
+
+	var watcher *fileWatcher
+	if len(watchPaths) > 0 {
+		w, err := newFileWatcher(watchPaths, *watchDebounce, restartOnChange(registry, *stopTimeout))
+		if err != nil {
+			log.Fatalf("Invalid -watch: %v", err)
+		}
+		watcher = w
+	}
+
+	if *waitMode {
+		if len(defs) != 1 || *noAutostart {
+			log.Fatal("-wait requires exactly one process and cannot be combined with -no-autostart")
+		}
+		manager, _ := registry.Get(defs[0].Name)
+		if err := manager.Start(); err != nil {
+			log.Printf("Initial start of %q failed: %v", defs[0].Name, err)
+		}
+		os.Exit(runToCompletion(manager, *stopTimeout))
+	}
+
+	registry.Each(func(name string, manager *ProcessManager) {
+		if manager.Reattach() || *noAutostart {
+			return
+		}
+		if err := manager.Start(); err != nil {
+			log.Printf("Initial start of %q failed: %v", name, err)
+		}
+	})
+
+	if watcher != nil {
+		log.Printf("Watching %s for changes.", strings.Join(watchPaths, ", "))
+		go watcher.run()
+	}
+
+	// Control endpoints always require the token when one is configured;
+	// read-only endpoints only with -auth-read. With -allow-ip, control
+	// endpoints are also limited to the listed clients, and with
+	// -control-rate they share one rate limit.
+	var controlBucket *tokenBucket
+	if *controlRate > 0 {
+		controlBucket = newTokenBucket(*controlRate, *controlBurst)
+	}
+	protect := func(h http.HandlerFunc, control bool) http.HandlerFunc {
+		if control && controlBucket != nil {
+			h = rateLimit(controlBucket, h)
+		}
+		if *authToken != "" && (control || *authRead) {
+			h = requireToken(*authToken, h)
+		}
+		if control && len(allowed) > 0 {
+			h = requireAllowedIP(allowed, proxies, h)
+		}
+		return h
+	}
+
+	// With -admin-addr the control endpoints are only served on the admin
+	// listener, which serves the read-only endpoints as well; the main
+	// listener keeps only the read-only ones.
+	mux := http.NewServeMux()
+	var adminMux *http.ServeMux
+	if *adminAddr != "" {
+		adminMux = http.NewServeMux()
+	}
+	handle := func(pattern string, h http.HandlerFunc, control bool) {
+		if adminMux != nil {
+			adminMux.HandleFunc(pattern, h)
+			if control {
+				return
+			}
+		}
+		mux.HandleFunc(pattern, h)
+	}
+
+	// The unprefixed routes address the first registered process so
+	// single-process setups keep working unchanged. Replicas of the process
+	// given on the command line are controlled together instead.
+	primary, _ := registry.Get(defs[0].Name)
+	if group != nil {
+		for _, id := range group.ids {
+			pm, _ := registry.Get(id)
+			group.replicas = append(group.replicas, pm)
+		}
+	}
+	processRoutes := []processRoute{
+		{path: "status", makeHandler: makeStatusHandler, makeGroupHandler: makeReplicaStatusHandler},
+		{path: "stats", makeHandler: makeStatsHandler},
+		{path: "history", makeHandler: makeHistoryHandler},
+		{path: "owns",
+			makeHandler:      func(pm *ProcessManager) http.HandlerFunc { return makeOwnsHandler(pm) },
+			makeGroupHandler: func(g *replicaGroup) http.HandlerFunc { return makeOwnsHandler(g.replicas...) },
+		},
+		{path: "start", control: true,
+			makeHandler:      func(pm *ProcessManager) http.HandlerFunc { return makeStartHandler(pm) },
+			makeGroupHandler: func(g *replicaGroup) http.HandlerFunc { return makeStartHandler(g) },
+		},
+		{path: "stop", control: true, long: true,
+			makeHandler:      func(pm *ProcessManager) http.HandlerFunc { return makeStopHandler(pm, *stopTimeout) },
+			makeGroupHandler: func(g *replicaGroup) http.HandlerFunc { return makeStopHandler(g, *stopTimeout) },
+		},
+		{path: "restart", control: true, long: true,
+			makeHandler:      func(pm *ProcessManager) http.HandlerFunc { return makeRestartHandler(pm, *stopTimeout) },
+			makeGroupHandler: func(g *replicaGroup) http.HandlerFunc { return makeRestartHandler(g, *stopTimeout) },
+		},
+		{path: "kill", control: true,
+			makeHandler:      func(pm *ProcessManager) http.HandlerFunc { return makeKillHandler(pm) },
+			makeGroupHandler: func(g *replicaGroup) http.HandlerFunc { return makeKillHandler(g) },
+		},
+		{path: "signal", control: true, makeHandler: makeSignalHandler},
+		{path: "drain", control: true, makeHandler: makeDrainHandler},
+		{path: "stdin", control: true, long: true, makeHandler: makeStdinHandler},
+		{path: "config", control: true, long: true, makeHandler: func(pm *ProcessManager) http.HandlerFunc {
+			return makeConfigHandler(pm, *stopTimeout)
+		}},
+		{path: "config/args/append", control: true, long: true, makeHandler: func(pm *ProcessManager) http.HandlerFunc {
+			return makeAppendArgHandler(pm, *stopTimeout)
+		}},
+		{path: "ws", control: true, long: true, method: http.MethodGet, makeHandler: func(pm *ProcessManager) http.HandlerFunc {
+			return makeWebSocketHandler(pm, *stopTimeout, controlBucket)
+		}},
+		{path: "log", long: true, makeHandler: makeLogHandler},
+		{path: "stderr", makeHandler: makeStderrHandler},
+		{path: "log/stream", long: true, makeHandler: makeLogStreamHandler},
+		{path: "log/clear", control: true, makeHandler: makeClearLogsHandler},
+	}
+	for _, route := range processRoutes {
+		handler := route.makeHandler(primary)
+		if group != nil && route.makeGroupHandler != nil {
+			handler = route.makeGroupHandler(group)
+		}
+		method := route.method
+		if method == "" {
+			method = http.MethodGet
+			if route.control {
+				method = http.MethodPost
+			}
+		}
+		perProcess := withProcess(registry, route.makeHandler)
+		if route.long {
+			handler = withoutTimeouts(handler)
+			perProcess = withoutTimeouts(perProcess)
+		}
+		handle("/"+route.path, allowMethod(method, protect(handler, route.control)), route.control)
+		handle("/process/{id}/"+route.path, allowMethod(method, protect(perProcess, route.control)), route.control)
+	}
+	// Probes stay unauthenticated so orchestrators can reach them.
+	handle("/healthz", allowMethod(http.MethodGet, makeHealthHandler()), false)
+	handle("/readyz", allowMethod(http.MethodGet, makeReadyHandler(primary)), false)
+	handle("/process/{id}/readyz", allowMethod(http.MethodGet, withProcess(registry, makeReadyHandler)), false)
+	handle("/processes", allowMethod(http.MethodGet, protect(makeProcessesHandler(registry), false)), false)
+	handle("/version", allowMethod(http.MethodGet, protect(makeVersionHandler(), false)), false)
+	handle("/metrics", allowMethod(http.MethodGet, protect(makeMetricsHandler(registry), false)), false)
+	handle("/openapi.json", allowMethod(http.MethodGet, protect(makeOpenAPIHandler(), false)), false)
+	// The page is static; the API calls it makes are protected as usual.
+	// On the main listener it only offers controls if that serves them.
+	mux.HandleFunc("/dashboard", allowMethod(http.MethodGet, makeDashboardHandler(adminMux == nil)))
+	if adminMux != nil {
+		adminMux.HandleFunc("/dashboard", allowMethod(http.MethodGet, makeDashboardHandler(true)))
+	}
+	handle("/exit", allowMethod(http.MethodPost, protect(withoutTimeouts(makeExitHandler(registry, *stopTimeout)), true)), true)
+
+	// Signals to the manager are relayed to the children so gowork behaves
+	// as expected as a container's PID 1. SIGHUP is passed on after
+	// reloading the config file, if any, unless the reload restarts the
+	// manager; SIGINT and SIGTERM stop the children and then the manager.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	srv := &http.Server{
+		Addr:         ":" + *port,
+		Handler:      mux,
+		ReadTimeout:  *httpReadTimeout,
+		WriteTimeout: *httpWriteTimeout,
+		IdleTimeout:  *httpIdleTimeout,
+	}
+	if *tlsClientCA != "" {
+		srv.TLSConfig, err = loadClientCAConfig(*tlsClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var adminSrv *http.Server
+	if adminMux != nil {
+		adminSrv = &http.Server{
+			Addr:         *adminAddr,
+			Handler:      adminMux,
+			TLSConfig:    srv.TLSConfig,
+			ReadTimeout:  srv.ReadTimeout,
+			WriteTimeout: srv.WriteTimeout,
+			IdleTimeout:  srv.IdleTimeout,
+		}
+	}
+	var ln net.Listener
+	var where string
+	if *socketPath != "" {
+		ln, err = listenUnix(*socketPath)
+		where = "socket " + *socketPath
+	} else {
+		ln, err = listen(*port, *portRetry, *portFallback)
+		if err == nil {
+			where = fmt.Sprintf("port %d", ln.Addr().(*net.TCPAddr).Port)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	serve := func(srv *http.Server, ln net.Listener, kind, where string) {
+		var err error
+		if useTLS {
+			log.Printf("Starting TLS %s on %s...", kind, where)
+			err = srv.ServeTLS(ln, *tlsCert, *tlsKey)
+		} else {
+			log.Printf("Starting %s on %s...", kind, where)
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start %s: %v", kind, err)
+		}
+	}
+	if adminSrv != nil {
+		adminLn, err := net.Listen("tcp", *adminAddr)
+		if err != nil {
+			log.Fatalf("Failed to start admin server: %v", err)
+		}
+		go serve(adminSrv, adminLn, "admin server", adminLn.Addr().String())
+	}
+	go serve(srv, ln, "server", where)
+
+	var received syscall.Signal
+	restartManager := false
+	for sig := range signals {
+		received = sig.(syscall.Signal)
+		if received != syscall.SIGHUP {
+			break
+		}
+		if reloader != nil {
+			log.Printf("Received %s, reloading %s.", signalName(received), *configFile)
+			pending, err := reloader.Reload()
+			if err != nil {
+				log.Printf("Config reload failed: %v", err)
+			} else if *reexecOnChange && len(pending) > 0 {
+				log.Printf("Config reload: restarting the manager to apply %s.", strings.Join(pending, ", "))
+				restartManager = true
+				break
+			}
+		}
+		log.Printf("Received %s, forwarding to managed processes.", signalName(received))
+		registry.Each(func(name string, manager *ProcessManager) {
+			if err := manager.Signal(received); err != nil && !errors.Is(err, ErrNotRunning) {
+				log.Printf("Failed to forward %s to %q: %v", signalName(received), name, err)
+			}
+		})
+	}
+	if restartManager {
+		log.Println("Stopping the processes and the server before restarting the manager...")
+	} else {
+		log.Printf("Received %s, shutting down...", signalName(received))
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeoutFlag)
+	defer cancel()
+
+	// Relay the signal first so the children begin shutting down while the
+	// HTTP server drains.
+	var stopping []*ProcessManager
+	registry.Each(func(name string, manager *ProcessManager) {
+		sig := received
+		if restartManager {
+			sig = manager.stopSignal
+		}
+		if err := manager.StopWithSignal(sig, *stopTimeout); err == nil {
+			stopping = append(stopping, manager)
+		}
+	})
+	clean := true
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown failed: %v", err)
+		clean = false
+	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Admin server shutdown failed: %v", err)
+			clean = false
+		}
+	}
+	for _, manager := range stopping {
+		if err := manager.WaitForExit(shutdownCtx); err != nil {
+			log.Printf("Process %q did not exit within %v, killing it.", manager.name, *shutdownTimeoutFlag)
+			if err := manager.ForceKill(); err != nil && !errors.Is(err, ErrNotRunning) {
+				log.Printf("Failed to kill %q: %v", manager.name, err)
+			}
+			clean = false
+		}
+	}
+	if restartManager {
+		if !clean {
+			log.Println("Shutdown deadline exceeded; restarting the manager anyway.")
+		}
+		reexec()
+	}
+	if !clean {
+		log.Println("Shutdown deadline exceeded.")
+		exit(1)
 	}
+	log.Println("Shutdown complete.")
 }
@@ -0,0 +1,302 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "gowork/api"
+)
+
+// newTestManager returns a quiet manager of the shell script script.
+func newTestManager(script string, cfg ProcessConfig) *ProcessManager {
+    cfg.Name = "test"
+    cfg.ExecutablePath = "/bin/sh"
+    cfg.Args = []string{"-c", script}
+    cfg.Quiet = true
+    return NewProcessManager(cfg)
+}
+
+func TestStatusDuringSlowPostStopHook(t *testing.T) {
+    hookDone := filepath.Join(t.TempDir(), "hook-done")
+    pm := newTestManager("sleep 0.3", ProcessConfig{PostStop: "sleep 1 && touch " + hookDone})
+    if err := pm.Start(); err != nil {
+        t.Fatalf("Start: %v", err)
+    }
+
+    // The status follows the exit, not the end of the hook.
+    deadline := time.Now().Add(time.Second)
+    for pm.GetStatus() == StatusRunning {
+        if time.Now().After(deadline) {
+            t.Fatal("status still running 1s after starting a process that exits after 0.3s")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    if _, err := os.Stat(hookDone); err == nil {
+        t.Fatal("status changed only once the post-stop hook had finished")
+    }
+    if status := pm.GetStatus(); status != StatusSuccess {
+        t.Errorf("status = %q, want %q", status, StatusSuccess)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := pm.WaitForExit(ctx); err != nil {
+        t.Fatalf("WaitForExit: %v", err)
+    }
+    if _, err := os.Stat(hookDone); err != nil {
+        t.Error("WaitForExit returned before the post-stop hook finished")
+    }
+}
+
+func TestArgsPassedVerbatim(t *testing.T) {
+    pm := newTestManager("", ProcessConfig{})
+    args := []string{"a b", `"q"`, ""}
+    // The shell prints each argument after the script on a line of its own.
+    script := `for arg in "$@"; do printf '<%s>\n' "$arg"; done`
+    if err := pm.SetArgs(append([]string{"-c", script, "sh"}, args...)); err != nil {
+        t.Fatalf("SetArgs: %v", err)
+    }
+    if err := pm.Start(); err != nil {
+        t.Fatalf("Start: %v", err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := pm.WaitForExit(ctx); err != nil {
+        t.Fatalf("WaitForExit: %v", err)
+    }
+
+    stdout, _, _ := pm.logs.Snapshot(StreamStdout)
+    var got []string
+    for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+        got = append(got, strings.TrimSuffix(strings.TrimPrefix(line, "<"), ">"))
+    }
+    if !slices.Equal(got, args) {
+        t.Errorf("process received arguments %q, want %q", got, args)
+    }
+}
+
+func TestArgsWithNULRejected(t *testing.T) {
+    pm := newTestManager("true", ProcessConfig{})
+    if err := pm.SetArgs([]string{"a\x00b"}); err == nil {
+        t.Error("SetArgs accepted an argument containing a NUL byte")
+    }
+
+    req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(`{"args": ["a\u0000b"]}`))
+    rec := httptest.NewRecorder()
+    makeConfigHandler(pm, time.Second)(rec, req)
+    if rec.Code != http.StatusBadRequest {
+        t.Errorf("/config with a NUL byte in an argument: status %d, want %d", rec.Code, http.StatusBadRequest)
+    }
+    if want := []string{"-c", "true"}; !slices.Equal(pm.args, want) {
+        t.Errorf("arguments after rejected updates = %q, want %q", pm.args, want)
+    }
+}
+
+func TestExpandArgs(t *testing.T) {
+    env := []string{"NAME=world", "PORT=8080", "PORT=9090", "EMPTY="}
+    tests := []struct {
+        arg  string
+        want string
+    }{
+        {"$NAME", "world"},
+        {"${NAME}", "world"},
+        {"hello-${NAME}!", "hello-world!"},
+        {"--port=$PORT", "--port=9090"}, // The later entry wins.
+        {"$UNDEFINED", ""},
+        {"a${UNDEFINED}b", "ab"},
+        {"[$EMPTY]", "[]"},
+        {"$$", "$"},
+        {"cost: $$5", "cost: $5"},
+        {"plain", "plain"},
+    }
+    for _, tt := range tests {
+        if got := expandArgs([]string{tt.arg}, env); len(got) != 1 || got[0] != tt.want {
+            t.Errorf("expandArgs(%q) = %q, want %q", tt.arg, got, tt.want)
+        }
+    }
+}
+
+func TestExitHandler(t *testing.T) {
+    exitCode := -1
+    defer func(orig func(int)) { exit = orig }(exit)
+    exit = func(code int) { exitCode = code }
+
+    reg := NewRegistry()
+    pm := newTestManager("exec sleep 10", ProcessConfig{})
+    if err := reg.Register("test", pm); err != nil {
+        t.Fatalf("Register: %v", err)
+    }
+    if err := pm.Start(); err != nil {
+        t.Fatalf("Start: %v", err)
+    }
+
+    rec := httptest.NewRecorder()
+    makeExitHandler(reg, time.Second)(rec, httptest.NewRequest(http.MethodPost, "/exit", nil))
+
+    if exitCode != 0 {
+        t.Errorf("exit code = %d, want 0", exitCode)
+    }
+    if rec.Code != http.StatusOK {
+        t.Errorf("status %d, want %d", rec.Code, http.StatusOK)
+    }
+    if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(rec.Body.Len()); got != want {
+        t.Errorf("Content-Length = %q, want %q", got, want)
+    }
+    var report api.ExitReport
+    if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+        t.Fatalf("body %q is not an exit report: %v", rec.Body, err)
+    }
+    if !slices.Equal(report.Stopped, []string{"test"}) || len(report.Unstopped) != 0 {
+        t.Errorf("report = %+v, want test stopped", report)
+    }
+}
+
+func TestNeverStartedProcess(t *testing.T) {
+    pm := newTestManager("true", ProcessConfig{})
+    routes := []struct {
+        target  string
+        handler http.HandlerFunc
+    }{
+        {"/stop", makeStopHandler(pm, time.Second)},
+        {"/kill", makeKillHandler(pm)},
+        {"/signal?name=HUP", makeSignalHandler(pm)},
+        {"/stdin", makeStdinHandler(pm)},
+        {"/drain", makeDrainHandler(pm)},
+        {"/stats", makeStatsHandler(pm)},
+    }
+    for _, route := range routes {
+        rec := httptest.NewRecorder()
+        route.handler(rec, httptest.NewRequest(http.MethodPost, route.target, strings.NewReader("input\n")))
+        if rec.Code != http.StatusConflict {
+            t.Errorf("%s: status %d, want %d", route.target, rec.Code, http.StatusConflict)
+        }
+        var resp api.ErrorResponse
+        if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+            t.Errorf("%s: body %q is not an error response: %v", route.target, rec.Body, err)
+        } else if resp.Code != api.CodeNotStarted {
+            t.Errorf("%s: code %q, want %q", route.target, resp.Code, api.CodeNotStarted)
+        }
+    }
+
+    rec := httptest.NewRecorder()
+    makeLogHandler(pm)(rec, httptest.NewRequest(http.MethodGet, "/log", nil))
+    if got := rec.Header().Get("X-Process-Status"); got != string(StatusNotStarted) {
+        t.Errorf("/log: X-Process-Status = %q, want %q", got, StatusNotStarted)
+    }
+
+    rec = httptest.NewRecorder()
+    makeStatusHandler(pm)(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+    var report api.StatusReport
+    if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+        t.Fatalf("/status: body %q is not a status report: %v", rec.Body, err)
+    }
+    if report.Status != StatusNotStarted {
+        t.Errorf("/status: status %q, want %q", report.Status, StatusNotStarted)
+    }
+}
+
+func TestHealthRestartsStopAtMaxRetries(t *testing.T) {
+    pm := newTestManager("exec sleep 10", ProcessConfig{
+        StopTimeout: time.Second,
+        HealthCheck: HealthCheck{Command: "false", Interval: 100 * time.Millisecond, Retries: 3},
+        Restart:     RestartPolicy{MaxRetries: 2, Backoff: 10 * time.Millisecond},
+    })
+    // Each run outlasts the start grace period before it is found unhealthy.
+    if err := pm.Start(); err != nil {
+        t.Fatalf("Start: %v", err)
+    }
+    defer pm.ForceKill()
+
+    // The first run and two restarts, each stopped as unhealthy.
+    deadline := time.Now().Add(5 * time.Second)
+    for len(pm.History()) < 3 || pm.GetStatus() != StatusUnhealthy {
+        if time.Now().After(deadline) {
+            t.Fatalf("after 5s: %d runs, status %q; want 3 runs ending unhealthy", len(pm.History()), pm.GetStatus())
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    // Well beyond the backoff, no further restart happens.
+    time.Sleep(300 * time.Millisecond)
+    if runs := len(pm.History()); runs != 3 {
+        t.Errorf("process ran %d times, want 3", runs)
+    }
+    if status := pm.GetStatus(); status != StatusUnhealthy {
+        t.Errorf("status = %q, want %q", status, StatusUnhealthy)
+    }
+    for _, ev := range pm.History() {
+        if ev.Status != StatusUnhealthy {
+            t.Errorf("run %d ended %q, want %q", ev.PID, ev.Status, StatusUnhealthy)
+        }
+    }
+}
+
+func TestStopCancelsPreStartHook(t *testing.T) {
+    pm := newTestManager("exec sleep 10", ProcessConfig{PreStart: "sleep 10"})
+    started := make(chan error, 1)
+    go func() { started <- pm.Start() }()
+
+    // GetStatus takes pm.mu, which the hook must not hold.
+    deadline := time.Now().Add(time.Second)
+    for pm.GetStatus() != StatusStarting {
+        if time.Now().After(deadline) {
+            t.Fatalf("status = %q during the pre-start hook, want %q", pm.GetStatus(), StatusStarting)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    if err := pm.Stop(); err != nil {
+        t.Fatalf("Stop: %v", err)
+    }
+    select {
+    case err := <-started:
+        if !errors.Is(err, ErrStartCancelled) {
+            t.Errorf("Start = %v, want %v", err, ErrStartCancelled)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("Start still waiting for the pre-start hook 1s after Stop")
+    }
+    if status := pm.GetStatus(); status != StatusStopped {
+        t.Errorf("status = %q, want %q", status, StatusStopped)
+    }
+}
+
+func TestOutputSpooledWithStateFile(t *testing.T) {
+    dir := t.TempDir()
+    state, err := OpenStateStore(filepath.Join(dir, "state.json"))
+    if err != nil {
+        t.Fatalf("OpenStateStore: %v", err)
+    }
+    pm := newTestManager("sleep 0.3; echo out; echo err >&2; printf last", ProcessConfig{State: state})
+    if err := pm.Start(); err != nil {
+        t.Fatalf("Start: %v", err)
+    }
+    spools, _ := filepath.Glob(filepath.Join(dir, "state.json.test.*"))
+    if len(spools) == 0 {
+        t.Error("no spool files next to the state file while the process runs")
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := pm.WaitForExit(ctx); err != nil {
+        t.Fatalf("WaitForExit: %v", err)
+    }
+    if stdout, _, _ := pm.logs.Snapshot(StreamStdout); stdout != "out\nlast" {
+        t.Errorf("stdout = %q, want %q", stdout, "out\nlast")
+    }
+    if stderr, _, _ := pm.logs.Snapshot(StreamStderr); stderr != "err\n" {
+        t.Errorf("stderr = %q, want %q", stderr, "err\n")
+    }
+    if spools, _ := filepath.Glob(filepath.Join(dir, "state.json.test.*")); len(spools) != 0 {
+        t.Errorf("spool files %q left after the process exited", spools)
+    }
+}
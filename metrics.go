@@ -0,0 +1,106 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// allStatuses lists every status so the status gauge reports 0 for the
+// inactive ones instead of omitting them.
+var allStatuses = []ProcessStatus{StatusNotStarted, StatusStarting, StatusRunning, StatusDraining, StatusSuccess, StatusFailed, StatusTimedOut, StatusUnhealthy, StatusStopped, StatusBackoff, StatusCrashLooping}
+
+// metricFamily is the name, help text and type of one exported metric.
+type metricFamily struct {
+    name string
+    help string
+    kind string
+}
+
+var (
+    statusMetric   = metricFamily{"gowork_process_status", "Current process status; 1 for the active status, 0 otherwise.", "gauge"}
+    restartsMetric = metricFamily{"gowork_process_restarts_total", "Number of times the process was restarted.", "counter"}
+    failuresMetric = metricFamily{"gowork_process_failures_total", "Number of times the process failed to start or exited with an error.", "counter"}
+    droppedMetric  = metricFamily{"gowork_log_lines_dropped_total", "Number of log lines missed by streaming clients that did not read fast enough.", "counter"}
+    uptimeMetric   = metricFamily{"gowork_process_uptime_seconds", "Seconds since the running process was started; 0 when not running.", "gauge"}
+    exitCodeMetric = metricFamily{"gowork_process_last_exit_code", "Exit code of the last run; absent until the process has exited.", "gauge"}
+)
+
+// makeMetricsHandler serves the state of every registered process in the
+// Prometheus text exposition format. Each process is read once, under its
+// lock, so the values of one process are consistent with each other.
+func makeMetricsHandler(reg *Registry) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        names := []string{}
+        metrics := map[string]ProcessMetrics{}
+        reg.Each(func(name string, pm *ProcessManager) {
+            names = append(names, name)
+            metrics[name] = pm.Metrics()
+        })
+
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+        out := bufio.NewWriter(w)
+        defer out.Flush()
+
+        writeHeader(out, statusMetric)
+        for _, name := range names {
+            for _, status := range allStatuses {
+                value := 0.0
+                if status == metrics[name].Status {
+                    value = 1
+                }
+                writeSample(out, statusMetric, value, processLabels(name, metrics[name], "status", string(status))...)
+            }
+        }
+        writeHeader(out, restartsMetric)
+        for _, name := range names {
+            writeSample(out, restartsMetric, float64(metrics[name].Restarts), processLabels(name, metrics[name])...)
+        }
+        writeHeader(out, failuresMetric)
+        for _, name := range names {
+            writeSample(out, failuresMetric, float64(metrics[name].Failures), processLabels(name, metrics[name])...)
+        }
+        writeHeader(out, droppedMetric)
+        for _, name := range names {
+            writeSample(out, droppedMetric, float64(metrics[name].LinesDropped), processLabels(name, metrics[name])...)
+        }
+        writeHeader(out, uptimeMetric)
+        for _, name := range names {
+            writeSample(out, uptimeMetric, metrics[name].UptimeSeconds, processLabels(name, metrics[name])...)
+        }
+        writeHeader(out, exitCodeMetric)
+        for _, name := range names {
+            if code := metrics[name].ExitCode; code != nil {
+                writeSample(out, exitCodeMetric, float64(*code), processLabels(name, metrics[name])...)
+            }
+        }
+    }
+}
+
+// processLabels returns the label pairs of a sample of the named process:
+// its name, its own labels and then extra.
+func processLabels(name string, m ProcessMetrics, extra ...string) []string {
+    labels := append([]string{"process", name}, labelPairs(m.Labels)...)
+    return append(labels, extra...)
+}
+
+// writeHeader writes the HELP and TYPE lines of m.
+func writeHeader(w io.Writer, m metricFamily) {
+    fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+    fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+}
+
+// writeSample writes one sample of m with the given label name/value pairs.
+func writeSample(w io.Writer, m metricFamily, value float64, labels ...string) {
+    pairs := make([]string, 0, len(labels)/2)
+    for i := 0; i+1 < len(labels); i += 2 {
+        pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1])))
+    }
+    fmt.Fprintf(w, "%s{%s} %s\n", m.name, strings.Join(pairs, ","), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// labelEscaper escapes label values as the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
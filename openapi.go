@@ -0,0 +1,20 @@
+package main
+
+import (
+    _ "embed"
+    "net/http"
+)
+
+// openAPISpec describes the HTTP API. It is written by hand, so a change to
+// the routes or to the types in package api must be reflected in it.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// makeOpenAPIHandler serves the OpenAPI description of the API.
+func makeOpenAPIHandler() http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Write(openAPISpec)
+    }
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "gowork/api"
+)
+
+// clockTicks is the kernel's USER_HZ, in which /proc reports CPU times.
+// It is 100 on all mainstream Linux platforms.
+const clockTicks = 100
+
+// ProcessStats is a sample of the resource usage of a running process.
+type ProcessStats = api.ProcessStats
+
+// readParentPID returns the parent PID of a process from /proc/<pid>/stat.
+func readParentPID(pid int) (int, error) {
+    path := filepath.Join("/proc", strconv.Itoa(pid), "stat")
+    stat, err := os.ReadFile(path)
+    if err != nil {
+        return 0, err
+    }
+    // The parent PID is field 4, right after the state.
+    i := bytes.LastIndexByte(stat, ')')
+    if i < 0 {
+        return 0, fmt.Errorf("malformed %s", path)
+    }
+    fields := strings.Fields(string(stat[i+1:]))
+    if len(fields) < 2 {
+        return 0, fmt.Errorf("malformed %s", path)
+    }
+    return strconv.Atoi(fields[1])
+}
+
+// readProcStats samples CPU time from /proc/<pid>/stat and resident memory
+// from /proc/<pid>/status.
+func readProcStats(pid int) (ProcessStats, error) {
+    dir := filepath.Join("/proc", strconv.Itoa(pid))
+    stats := ProcessStats{PID: pid, SampledAt: time.Now()}
+
+    stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+    if err != nil {
+        return stats, fmt.Errorf("failed to read process stats: %w", err)
+    }
+    // Fields after the parenthesised command name start with the state
+    // (field 3); utime and stime are fields 14 and 15.
+    i := bytes.LastIndexByte(stat, ')')
+    if i < 0 {
+        return stats, fmt.Errorf("malformed %s/stat", dir)
+    }
+    fields := strings.Fields(string(stat[i+1:]))
+    if len(fields) < 13 {
+        return stats, fmt.Errorf("malformed %s/stat", dir)
+    }
+    utime, err := strconv.ParseUint(fields[11], 10, 64)
+    if err != nil {
+        return stats, fmt.Errorf("malformed utime in %s/stat: %w", dir, err)
+    }
+    stime, err := strconv.ParseUint(fields[12], 10, 64)
+    if err != nil {
+        return stats, fmt.Errorf("malformed stime in %s/stat: %w", dir, err)
+    }
+    stats.CPUUserSeconds = float64(utime) / clockTicks
+    stats.CPUSystemSeconds = float64(stime) / clockTicks
+
+    status, err := os.Open(filepath.Join(dir, "status"))
+    if err != nil {
+        return stats, fmt.Errorf("failed to read process status: %w", err)
+    }
+    defer status.Close()
+
+    scanner := bufio.NewScanner(status)
+    for scanner.Scan() {
+        value, ok := strings.CutPrefix(scanner.Text(), "VmRSS:")
+        if !ok {
+            continue
+        }
+        // Reported as "<n> kB".
+        kb, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+        if err != nil {
+            return stats, fmt.Errorf("malformed VmRSS in %s/status: %w", dir, err)
+        }
+        stats.RSSBytes = kb * 1024
+        break
+    }
+    return stats, scanner.Err()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    "gowork/api"
+)
+
+// tokenBucket is a token-bucket rate limiter: it holds up to burst tokens,
+// refills at rate tokens per second, and each allowed request takes one.
+type tokenBucket struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+// newTokenBucket returns a full bucket allowing rate requests per second on
+// average and bursts of up to burst requests.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+    if burst < 1 {
+        burst = 1
+    }
+    return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Allow takes a token if one is available. Otherwise it reports how long
+// until the next token.
+func (b *tokenBucket) Allow() (bool, time.Duration) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    now := time.Now()
+    b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+    b.last = now
+    if b.tokens >= 1 {
+        b.tokens--
+        return true, 0
+    }
+    return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimit rejects requests with 429 Too Many Requests once bucket is empty.
+func rateLimit(bucket *tokenBucket, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if ok, wait := bucket.Allow(); !ok {
+            log.Printf("API: %s rejected: control rate limit exceeded", r.URL.Path)
+            w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+            writeError(w, http.StatusTooManyRequests, api.CodeRateLimited, "Too Many Requests")
+            return
+        }
+        next(w, r)
+    }
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ProcessDefinition describes one process entry of a definitions file.
+// Every element of Args is passed to the process verbatim as one argument;
+// nothing is split on spaces or unquoted, and environment variables are
+// only expanded if ExpandArgs is set. Shell instead runs Path and Args as a
+// shell command line; see viaShell.
+type ProcessDefinition struct {
+    Name       string            `json:"name"`
+    Path       string            `json:"path"`
+    Args       []string          `json:"args"`
+    ExpandArgs bool              `json:"expand_args"`
+    Shell      bool              `json:"shell"`
+    Workdir    string            `json:"workdir"`
+    Env        []string          `json:"env"`
+    EnvClean   bool              `json:"env_clean"`
+    LogFile    string            `json:"log_file"`
+    PreStart   string            `json:"pre_start"`
+    PostStop   string            `json:"post_stop"`
+    HealthCmd  string            `json:"health_cmd"`
+    HealthURL  string            `json:"health_url"`
+    Labels     map[string]string `json:"labels"`
+}
+
+// shellPath is the shell that runs the command line of a definition with
+// Shell set.
+const shellPath = "/bin/sh"
+
+// viaShell returns def rewritten to run its path and arguments, joined with
+// spaces, as one command line with sh -c. The shell interprets that line:
+// quotes, $VAR, globs, pipes, redirects and ; all take effect, so any part of
+// it that comes from outside can run arbitrary commands. Only use it for
+// command lines written by the operator, and prefer direct execution, the
+// default, whenever no shell features are needed. Signals reach only the
+// shell unless the process runs in its own process group.
+func (def ProcessDefinition) viaShell() ProcessDefinition {
+    def.Args = []string{"-c", strings.Join(append([]string{def.Path}, def.Args...), " ")}
+    def.Path = shellPath
+    def.Shell = false
+    return def
+}
+
+// loadProcessDefinitions reads a JSON array of process definitions from path.
+func loadProcessDefinitions(path string) ([]ProcessDefinition, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read process definitions: %w", err)
+    }
+
+    var defs []ProcessDefinition
+    if err := json.Unmarshal(data, &defs); err != nil {
+        return nil, fmt.Errorf("failed to parse process definitions %s: %w", path, err)
+    }
+    for i, def := range defs {
+        if def.Name == "" || def.Path == "" {
+            return nil, fmt.Errorf("process definition %d: name and path are required", i)
+        }
+        if def.HealthCmd != "" && def.HealthURL != "" {
+            return nil, fmt.Errorf("process definition %d: health_cmd and health_url are mutually exclusive", i)
+        }
+    }
+    return defs, nil
+}
+
+// resolveExecutable returns the absolute path of the executable named by
+// path, searching PATH when no such file exists, as for a bare command name
+// like "python". Resolving once keeps restarts on the same binary even if
+// PATH changes later.
+func resolveExecutable(path string) (string, error) {
+    if _, err := os.Stat(path); err != nil {
+        resolved, lookErr := exec.LookPath(path)
+        if lookErr != nil {
+            return "", fmt.Errorf("executable not found: %s", path)
+        }
+        path = resolved
+    }
+    return filepath.Abs(path)
+}
+
+// execPollInterval is how often waitForExecutable looks for the executable.
+const execPollInterval = 250 * time.Millisecond
+
+// waitForExecutable resolves path like resolveExecutable, retrying until
+// deadline while no executable is found, for executables on a volume that
+// is mounted shortly after the manager starts.
+func waitForExecutable(path string, deadline time.Time) (string, error) {
+    waiting := false
+    for {
+        resolved, err := resolveExecutable(path)
+        if err == nil || !time.Now().Before(deadline) {
+            return resolved, err
+        }
+        if !waiting {
+            log.Printf("Executable %s not found yet, waiting up to %v.", path, time.Until(deadline).Round(time.Millisecond))
+            waiting = true
+        }
+        time.Sleep(execPollInterval)
+    }
+}
+
+// checkExecutable verifies that path is still an executable file.
+func checkExecutable(path string) error {
+    info, err := os.Stat(path)
+    if os.IsNotExist(err) {
+        return fmt.Errorf("executable %s no longer exists", path)
+    }
+    if err != nil {
+        return fmt.Errorf("failed to check executable: %w", err)
+    }
+    if info.IsDir() || info.Mode().Perm()&0111 == 0 {
+        return fmt.Errorf("%s is not an executable file", path)
+    }
+    return nil
+}
+
+// validateArgs checks that every argument can be passed to a process. Any
+// string is a valid argument, including empty ones and ones containing
+// spaces or quotes, except for one containing a NUL byte.
+func validateArgs(args []string) error {
+    for i, arg := range args {
+        if strings.IndexByte(arg, 0) >= 0 {
+            return fmt.Errorf("invalid argument %d %q: contains a NUL byte", i, arg)
+        }
+    }
+    return nil
+}
+
+// Registry holds the managed processes keyed by name, in registration order.
+type Registry struct {
+    mu        sync.RWMutex
+    processes map[string]*ProcessManager
+    names     []string
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+    return &Registry{processes: make(map[string]*ProcessManager)}
+}
+
+// Register adds a process under name. Names must be unique.
+func (reg *Registry) Register(name string, pm *ProcessManager) error {
+    reg.mu.Lock()
+    defer reg.mu.Unlock()
+
+    if _, exists := reg.processes[name]; exists {
+        return fmt.Errorf("process %q is already registered", name)
+    }
+    reg.processes[name] = pm
+    reg.names = append(reg.names, name)
+    return nil
+}
+
+// Get returns the process registered under name.
+func (reg *Registry) Get(name string) (*ProcessManager, bool) {
+    reg.mu.RLock()
+    defer reg.mu.RUnlock()
+    pm, ok := reg.processes[name]
+    return pm, ok
+}
+
+// Names returns the registered process names in registration order.
+func (reg *Registry) Names() []string {
+    reg.mu.RLock()
+    defer reg.mu.RUnlock()
+    return append([]string(nil), reg.names...)
+}
+
+// Each calls fn for every registered process in registration order.
+func (reg *Registry) Each(fn func(name string, pm *ProcessManager)) {
+    for _, name := range reg.Names() {
+        pm, _ := reg.Get(name)
+        fn(name, pm)
+    }
+}
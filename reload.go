@@ -0,0 +1,162 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "reflect"
+    "slices"
+    "sort"
+    "strings"
+)
+
+// reloadableFlags are the settings a config reload applies to the running
+// manager. Everything else only takes effect when the manager restarts.
+var reloadableFlags = map[string]bool{
+    "log-max-bytes":              true,
+    "max-retries":                true,
+    "restart-backoff":            true,
+    "restart-backoff-multiplier": true,
+    "restart-backoff-max":        true,
+    "restart-stable-period":      true,
+    "crash-loop-threshold":       true,
+    "crash-loop-count":           true,
+    "restart-on-codes":           true,
+    "no-restart-on-codes":        true,
+    "webhook-url":                true,
+}
+
+// configReloader re-reads the -config file and applies the changed
+// reloadable settings to the flags they correspond to, then calls apply to
+// hand the new flag values to the running processes.
+type configReloader struct {
+    path       string
+    fs         *flag.FlagSet
+    explicit   map[string]bool     // Flags given on the command line, which the file never overrides.
+    applied    map[string][]string // File values in effect, per flag.
+    executable []string            // Executable and arguments the file configured at startup.
+    apply      func()
+}
+
+// newConfigReloader records the settings of cfg, the file at path, as in
+// effect. It must be called before cfg is applied to fs, so it can tell
+// flags given on the command line apart from those set by the file.
+func newConfigReloader(path string, cfg *FileConfig, fs *flag.FlagSet) *configReloader {
+    explicit := make(map[string]bool)
+    fs.Visit(func(f *flag.Flag) {
+        explicit[f.Name] = true
+    })
+    return &configReloader{
+        path:       path,
+        fs:         fs,
+        explicit:   explicit,
+        applied:    cfg.flagValues(),
+        executable: fileCommand(cfg),
+    }
+}
+
+// fileCommand returns the executable and arguments configured by cfg.
+func fileCommand(cfg *FileConfig) []string {
+    if cfg.Executable == "" {
+        return nil
+    }
+    return append([]string{cfg.Executable}, cfg.Args...)
+}
+
+// Reload re-reads the file and applies what changed, logging every change
+// and every one that cannot be applied without restarting the manager. It
+// returns the names of the latter, with "executable" standing for a changed
+// executable or args. An invalid file or value leaves the current settings
+// untouched.
+func (r *configReloader) Reload() ([]string, error) {
+    cfg, err := loadFileConfig(r.path)
+    if err != nil {
+        return nil, err
+    }
+    values := cfg.flagValues()
+
+    names := make(map[string]bool)
+    for name := range r.applied {
+        names[name] = true
+    }
+    for name := range values {
+        names[name] = true
+    }
+    sorted := make([]string, 0, len(names))
+    for name := range names {
+        sorted = append(sorted, name)
+    }
+    sort.Strings(sorted)
+
+    var changed, pending []string
+    for _, name := range sorted {
+        if slices.Equal(r.applied[name], values[name]) {
+            continue
+        }
+        switch {
+        case r.explicit[name]:
+            log.Printf("Config reload: %s changed, but the command line sets it; ignoring.", name)
+        case !reloadableFlags[name]:
+            log.Printf("Config reload: %s changed, but only takes effect when the manager restarts; not applied.", name)
+            pending = append(pending, name)
+        default:
+            changed = append(changed, name)
+        }
+    }
+    if !slices.Equal(r.executable, fileCommand(cfg)) {
+        log.Println("Config reload: executable or args changed, but only take effect when the manager restarts; not applied.")
+        pending = append(pending, "executable")
+    }
+    // Checked now so a manager restarted for them does not fail on them.
+    for _, name := range pending {
+        if f := r.fs.Lookup(name); f != nil {
+            for _, value := range values[name] {
+                if err := checkFlagValue(f, value); err != nil {
+                    return nil, fmt.Errorf("invalid config value for %s: %w", name, err)
+                }
+            }
+        }
+    }
+    if len(changed) == 0 {
+        log.Println("Config reload: no runtime settings changed.")
+        return pending, nil
+    }
+
+    // Set every changed flag, or none of them.
+    previous := make(map[string]string, len(changed))
+    for _, name := range changed {
+        f := r.fs.Lookup(name)
+        previous[name] = f.Value.String()
+        value := f.DefValue // A setting removed from the file reverts to the default.
+        if v := values[name]; len(v) > 0 {
+            value = v[len(v)-1]
+        }
+        if err := r.fs.Set(name, value); err != nil {
+            for n, old := range previous {
+                r.fs.Set(n, old)
+            }
+            return nil, fmt.Errorf("invalid config value for %s: %w", name, err)
+        }
+    }
+    for _, name := range changed {
+        log.Printf("Config reload: %s changed from %q to %q.", name, previous[name], r.fs.Lookup(name).Value.String())
+        r.applied[name] = values[name]
+    }
+    r.apply()
+    log.Printf("Config reload: applied %s.", strings.Join(changed, ", "))
+    return pending, nil
+}
+
+// checkFlagValue reports whether value would be accepted by f, by setting it
+// on a fresh value of the same type rather than on f itself.
+func checkFlagValue(f *flag.Flag, value string) error {
+    t := reflect.TypeOf(f.Value)
+    if t.Kind() != reflect.Pointer {
+        return nil
+    }
+    v, ok := reflect.New(t.Elem()).Interface().(flag.Value)
+    if !ok {
+        return nil
+    }
+    return v.Set(value)
+}
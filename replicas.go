@@ -0,0 +1,150 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    "gowork/api"
+)
+
+// controller is the part of ProcessManager the start, stop, restart and
+// kill handlers use, so they can drive a replicaGroup as well.
+type controller interface {
+    Start() error
+    Stop() error
+    StopWithTimeout(d time.Duration) error
+    ForceKill() error
+    Restart(ctx context.Context, stopTimeout time.Duration) error
+    RestartBlueGreen(ctx context.Context, stopTimeout time.Duration) error
+    WaitForExit(ctx context.Context) error
+}
+
+// statusOf returns what /status serves for c: the report of a process or
+// the summary of a replica group.
+func statusOf(c controller) any {
+    if g, ok := c.(*replicaGroup); ok {
+        return g.Summary()
+    }
+    return c.(*ProcessManager).Report()
+}
+
+// replicaGroup controls the replicas of one worker together.
+type replicaGroup struct {
+    name     string
+    ids      []string
+    replicas []*ProcessManager
+}
+
+// replicaDefinitions expands def into n definitions named <name>-<index>.
+// Each replica learns its index from the GOWORK_REPLICA variable.
+func replicaDefinitions(def ProcessDefinition, n int) []ProcessDefinition {
+    defs := make([]ProcessDefinition, n)
+    for i := range defs {
+        d := def
+        d.Name = def.Name + "-" + strconv.Itoa(i)
+        d.Env = append(append([]string{}, def.Env...), "GOWORK_REPLICA="+strconv.Itoa(i))
+        defs[i] = d
+    }
+    return defs
+}
+
+// each applies op to every replica in order. Replicas for which op fails
+// with benign, such as starting one that already runs, are skipped; benign
+// is only returned if it applied to all of them.
+func (g *replicaGroup) each(op func(*ProcessManager) error, benign error) error {
+    var errs []error
+    skipped := 0
+    for i, pm := range g.replicas {
+        err := op(pm)
+        switch {
+        case err == nil:
+        case errors.Is(err, benign):
+            skipped++
+        default:
+            errs = append(errs, fmt.Errorf("replica %s: %w", g.ids[i], err))
+        }
+    }
+    if len(errs) > 0 {
+        return errors.Join(errs...)
+    }
+    if skipped == len(g.replicas) {
+        return benign
+    }
+    return nil
+}
+
+// Start starts every replica that is not running.
+func (g *replicaGroup) Start() error {
+    return g.each((*ProcessManager).Start, ErrAlreadyRunning)
+}
+
+// Stop stops every running replica.
+func (g *replicaGroup) Stop() error {
+    return g.each((*ProcessManager).Stop, ErrNotRunning)
+}
+
+// StopWithTimeout stops every running replica, escalating to SIGKILL after d.
+func (g *replicaGroup) StopWithTimeout(d time.Duration) error {
+    return g.each(func(pm *ProcessManager) error { return pm.StopWithTimeout(d) }, ErrNotRunning)
+}
+
+// ForceKill kills every running replica.
+func (g *replicaGroup) ForceKill() error {
+    return g.each((*ProcessManager).ForceKill, ErrNotRunning)
+}
+
+// Restart restarts the replicas one after another, so the others keep
+// serving while each one restarts.
+func (g *replicaGroup) Restart(ctx context.Context, stopTimeout time.Duration) error {
+    return g.each(func(pm *ProcessManager) error { return pm.Restart(ctx, stopTimeout) }, nil)
+}
+
+// RestartBlueGreen replaces the replicas one after another, so all but one
+// keep serving throughout.
+func (g *replicaGroup) RestartBlueGreen(ctx context.Context, stopTimeout time.Duration) error {
+    return g.each(func(pm *ProcessManager) error { return pm.RestartBlueGreen(ctx, stopTimeout) }, nil)
+}
+
+// WaitForExit waits until no replica is running any more.
+func (g *replicaGroup) WaitForExit(ctx context.Context) error {
+    for _, pm := range g.replicas {
+        if err := pm.WaitForExit(ctx); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Summary returns the combined status of the replicas.
+func (g *replicaGroup) Summary() api.ReplicaSummary {
+    summary := api.ReplicaSummary{
+        Name:     g.name,
+        Replicas: len(g.replicas),
+        Statuses: make(map[api.ProcessStatus]int),
+    }
+    for i, pm := range g.replicas {
+        status := pm.GetStatus()
+        summary.Statuses[status]++
+        if status == StatusRunning {
+            summary.Running++
+        }
+        summary.Members = append(summary.Members, api.ProcessInfo{ID: g.ids[i], Status: status})
+    }
+    return summary
+}
+
+// makeReplicaStatusHandler returns the combined status of a replica group.
+func makeReplicaStatusHandler(g *replicaGroup) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        summary := g.Summary()
+        log.Printf("API: /status requested. Replicas running: %d/%d", summary.Running, summary.Replicas)
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(summary)
+    }
+}
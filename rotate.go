@@ -0,0 +1,137 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// backupTimeFormat names rotated files so they sort chronologically.
+const backupTimeFormat = "20060102T150405.000000000"
+
+// rotatingFile is an append-only log file that is rotated once it would
+// exceed maxSize bytes. Rotated files are kept as <path>.<timestamp> and
+// pruned by count and age. It is safe for concurrent use.
+type rotatingFile struct {
+    mu         sync.Mutex
+    path       string
+    maxSize    int64         // 0 disables rotation.
+    maxBackups int           // 0 keeps all backups.
+    maxAge     time.Duration // 0 keeps backups regardless of age.
+    file       *os.File
+    size       int64
+}
+
+// newRotatingFile returns a rotating writer for path. The file is opened on first write.
+func newRotatingFile(path string, maxSize int64, maxBackups int, maxAge time.Duration) *rotatingFile {
+    return &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, maxAge: maxAge}
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if f.file == nil {
+        if err := f.openLocked(); err != nil {
+            return 0, err
+        }
+    }
+    if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+        if err := f.rotateLocked(); err != nil {
+            return 0, err
+        }
+    }
+
+    n, err := f.file.Write(p)
+    f.size += int64(n)
+    return n, err
+}
+
+// Reopen closes the current handle and opens the path again, so a file
+// moved or deleted by someone else is recreated for the next run.
+func (f *rotatingFile) Reopen() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if err := f.closeLocked(); err != nil {
+        return err
+    }
+    return f.openLocked()
+}
+
+// Close closes the underlying file.
+func (f *rotatingFile) Close() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.closeLocked()
+}
+
+func (f *rotatingFile) openLocked() error {
+    file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+    if err != nil {
+        return fmt.Errorf("failed to open log file: %w", err)
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return fmt.Errorf("failed to stat log file: %w", err)
+    }
+    f.file = file
+    f.size = info.Size()
+    return nil
+}
+
+func (f *rotatingFile) closeLocked() error {
+    if f.file == nil {
+        return nil
+    }
+    err := f.file.Close()
+    f.file = nil
+    return err
+}
+
+func (f *rotatingFile) rotateLocked() error {
+    if err := f.closeLocked(); err != nil {
+        return fmt.Errorf("failed to close log file: %w", err)
+    }
+    backup := f.path + "." + time.Now().Format(backupTimeFormat)
+    if err := os.Rename(f.path, backup); err != nil {
+        return fmt.Errorf("failed to rotate log file: %w", err)
+    }
+    f.pruneLocked()
+    return f.openLocked()
+}
+
+// pruneLocked removes backups beyond maxBackups and older than maxAge.
+func (f *rotatingFile) pruneLocked() {
+    matches, err := filepath.Glob(f.path + ".*")
+    if err != nil {
+        return
+    }
+    // Only files named by rotateLocked are backups; others that happen to
+    // share the prefix, such as app.log.gz, are left alone.
+    var backups []string
+    for _, match := range matches {
+        if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(match, f.path+".")); err == nil {
+            backups = append(backups, match)
+        }
+    }
+    // Oldest first, thanks to the timestamp suffix.
+    sort.Strings(backups)
+
+    for i, backup := range backups {
+        remove := f.maxBackups > 0 && i < len(backups)-f.maxBackups
+        if !remove && f.maxAge > 0 {
+            if info, err := os.Stat(backup); err == nil && time.Since(info.ModTime()) > f.maxAge {
+                remove = true
+            }
+        }
+        if remove {
+            os.Remove(backup)
+        }
+    }
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "syscall"
+)
+
+// signalsByName maps the supported signal names, without the SIG prefix.
+var signalsByName = map[string]syscall.Signal{
+    "HUP":   syscall.SIGHUP,
+    "INT":   syscall.SIGINT,
+    "QUIT":  syscall.SIGQUIT,
+    "KILL":  syscall.SIGKILL,
+    "USR1":  syscall.SIGUSR1,
+    "USR2":  syscall.SIGUSR2,
+    "TERM":  syscall.SIGTERM,
+    "CONT":  syscall.SIGCONT,
+    "STOP":  syscall.SIGSTOP,
+    "TSTP":  syscall.SIGTSTP,
+    "WINCH": syscall.SIGWINCH,
+}
+
+// parseSignal resolves a signal name such as "SIGHUP", "hup" or "HUP".
+func parseSignal(name string) (syscall.Signal, error) {
+    key := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+    sig, ok := signalsByName[key]
+    if !ok {
+        return 0, fmt.Errorf("unknown signal %q", name)
+    }
+    return sig, nil
+}
+
+// signalName returns the conventional SIG-prefixed name of sig.
+func signalName(sig syscall.Signal) string {
+    for name, s := range signalsByName {
+        if s == sig {
+            return "SIG" + name
+        }
+    }
+    return sig.String()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "os/exec"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// spoolPollInterval is how often a spool is checked for new output.
+const spoolPollInterval = 100 * time.Millisecond
+
+// spoolReleaseSize is how much consumed output a spool keeps on disk before
+// the space is released.
+const spoolReleaseSize = 1 << 20
+
+// Flags of fallocate(2) for releasing the space of a range of a file while
+// keeping its size, missing from package syscall.
+const (
+    fallocKeepSize  = 0x01
+    fallocPunchHole = 0x02
+)
+
+// outputSpool carries one output stream of a child through a regular file
+// instead of a pipe. A child writing to a pipe is killed by SIGPIPE once
+// the manager has died, while a file takes its output until a restarted
+// manager re-attaches and carries on where the last one stopped. The
+// manager follows the file, passing new output to w, and records the offset
+// it has consumed in a position file next to it. Consumed output is punched
+// out of the file so it does not take up space without bound.
+type outputSpool struct {
+    mu       sync.Mutex
+    path     string
+    child    *os.File // Handle the run and its hooks write to; nil for a re-attached run.
+    file     *os.File // Handle of the manager.
+    pos      *os.File // Holds the consumed offset.
+    offset   int64
+    saved    int64 // Offset last written to pos.
+    released int64 // Output before this offset has been punched out.
+    w        io.Writer
+    buf      []byte
+    stop     chan struct{}
+    stopped  chan struct{}
+}
+
+// createSpool creates an empty spool at path for a new run and starts
+// following it into w. The handle for the run is opened for appending, so
+// its writes land at the end no matter which offset it thinks it is at.
+func createSpool(path string, w io.Writer) (*outputSpool, error) {
+    child, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o600)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create output spool: %w", err)
+    }
+    if err := os.WriteFile(path+".pos", make([]byte, 8), 0o600); err != nil {
+        child.Close()
+        removeSpool(path)
+        return nil, fmt.Errorf("failed to create output spool: %w", err)
+    }
+    s, err := followSpool(path, w)
+    if err != nil {
+        child.Close()
+        removeSpool(path)
+        return nil, err
+    }
+    s.child = child
+    return s, nil
+}
+
+// followSpool starts passing the output in the spool at path to w, from
+// the offset consumed so far, for a run started by an earlier manager.
+func followSpool(path string, w io.Writer) (*outputSpool, error) {
+    // Punching out consumed output needs a writable handle.
+    file, err := os.OpenFile(path, os.O_RDWR, 0)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open output spool: %w", err)
+    }
+    pos, err := os.OpenFile(path+".pos", os.O_RDWR|os.O_CREATE, 0o600)
+    if err != nil {
+        file.Close()
+        return nil, fmt.Errorf("failed to open output spool: %w", err)
+    }
+    s := &outputSpool{
+        path:    path,
+        file:    file,
+        pos:     pos,
+        w:       w,
+        buf:     make([]byte, 32*1024),
+        stop:    make(chan struct{}),
+        stopped: make(chan struct{}),
+    }
+    var b [8]byte
+    if _, err := pos.ReadAt(b[:], 0); err == nil {
+        s.offset = int64(binary.LittleEndian.Uint64(b[:]))
+        s.saved = s.offset
+        s.released = s.offset
+    }
+    go s.follow()
+    return s, nil
+}
+
+func (s *outputSpool) follow() {
+    defer close(s.stopped)
+    ticker := time.NewTicker(spoolPollInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-s.stop:
+            return
+        case <-ticker.C:
+            s.Drain()
+        }
+    }
+}
+
+// Drain passes all output written to the spool so far to w.
+func (s *outputSpool) Drain() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for {
+        n, err := s.file.ReadAt(s.buf, s.offset)
+        if n > 0 {
+            s.w.Write(s.buf[:n])
+            s.offset += int64(n)
+        }
+        if err != nil || n == 0 {
+            break
+        }
+    }
+    if s.offset != s.saved {
+        var b [8]byte
+        binary.LittleEndian.PutUint64(b[:], uint64(s.offset))
+        s.pos.WriteAt(b[:], 0)
+        s.saved = s.offset
+    }
+
+    if s.offset-s.released >= spoolReleaseSize {
+        // Only whole blocks are freed.
+        end := s.offset &^ (4096 - 1)
+        if err := syscall.Fallocate(int(s.file.Fd()), fallocPunchHole|fallocKeepSize, 0, end); err != nil {
+            log.Printf("Failed to release space of output spool %s: %v", s.path, err)
+            // Not tried again until as much has been consumed again.
+            end = s.offset
+        }
+        s.released = end
+    }
+}
+
+// Close passes the remaining output to w and removes the spool; the run
+// writing to it must have exited.
+func (s *outputSpool) Close() {
+    close(s.stop)
+    <-s.stopped
+    s.Drain()
+    if s.child != nil {
+        s.child.Close()
+    }
+    s.file.Close()
+    s.pos.Close()
+    removeSpool(s.path)
+}
+
+// removeSpool deletes the spool at path and its position file.
+func removeSpool(path string) {
+    os.Remove(path)
+    os.Remove(path + ".pos")
+}
+
+// spoolOutput sends the output of cmd through new spools <base>.stdout and
+// <base>.stderr, followed into stdout and stderr.
+func spoolOutput(cmd *exec.Cmd, base string, stdout, stderr io.Writer) ([]*outputSpool, error) {
+    outSpool, err := createSpool(base+".stdout", stdout)
+    if err != nil {
+        return nil, err
+    }
+    errSpool, err := createSpool(base+".stderr", stderr)
+    if err != nil {
+        outSpool.Close()
+        return nil, err
+    }
+    cmd.Stdout = outSpool.child
+    cmd.Stderr = errSpool.child
+    return []*outputSpool{outSpool, errSpool}, nil
+}
+
+// resumeSpools follows the spools <base>.stdout and <base>.stderr of a run
+// started by an earlier manager into stdout and stderr. A missing spool is
+// left out.
+func resumeSpools(base string, stdout, stderr io.Writer) []*outputSpool {
+    var spools []*outputSpool
+    for _, stream := range []struct {
+        path string
+        w    io.Writer
+    }{{base + ".stdout", stdout}, {base + ".stderr", stderr}} {
+        s, err := followSpool(stream.path, stream.w)
+        if err != nil {
+            log.Printf("Output of the re-attached process is not available: %v", err)
+            continue
+        }
+        spools = append(spools, s)
+    }
+    return spools
+}
+
+// drainSpools passes the output written to spools so far on.
+func drainSpools(spools []*outputSpool) {
+    for _, s := range spools {
+        s.Drain()
+    }
+}
+
+// closeSpools passes the remaining output of spools on and removes them.
+func closeSpools(spools []*outputSpool) {
+    for _, s := range spools {
+        s.Close()
+    }
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// ProcessState is the persisted record of one managed process.
+type ProcessState struct {
+    PID       int           `json:"pid,omitempty"`
+    Status    ProcessStatus `json:"status"`
+    ExitCode  *int          `json:"exit_code,omitempty"`
+    StartedAt *time.Time    `json:"started_at,omitempty"`
+    Spool     string        `json:"spool,omitempty"` // Base path of the output spools of the run.
+}
+
+// StateStore persists the state of every managed process to a single JSON
+// file so a restarted manager can re-attach to children that outlived it.
+type StateStore struct {
+    mu     sync.Mutex
+    path   string
+    states map[string]ProcessState
+}
+
+// OpenStateStore loads the state file at path, starting empty if it does not exist yet.
+func OpenStateStore(path string) (*StateStore, error) {
+    s := &StateStore{path: path, states: make(map[string]ProcessState)}
+
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return s, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read state file: %w", err)
+    }
+    if err := json.Unmarshal(data, &s.states); err != nil {
+        return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+    }
+    return s, nil
+}
+
+// Get returns the recorded state of the named process.
+func (s *StateStore) Get(name string) (ProcessState, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    st, ok := s.states[name]
+    return st, ok
+}
+
+// Save records the state of the named process and rewrites the state file.
+// The file is replaced atomically so a crash never leaves it half-written.
+func (s *StateStore) Save(name string, st ProcessState) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.states[name] = st
+    data, err := json.MarshalIndent(s.states, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to encode state: %w", err)
+    }
+
+    tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+    if err != nil {
+        return fmt.Errorf("failed to write state file: %w", err)
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return fmt.Errorf("failed to write state file: %w", err)
+    }
+    if err := tmp.Close(); err != nil {
+        return fmt.Errorf("failed to write state file: %w", err)
+    }
+    if err := os.Rename(tmp.Name(), s.path); err != nil {
+        return fmt.Errorf("failed to write state file: %w", err)
+    }
+    return nil
+}
+
+// spoolBase returns a base path for the output spools of a new run of the
+// named process, next to the state file so a restarted manager finds them.
+func (s *StateStore) spoolBase(name string) string {
+    return s.path + "." + url.PathEscape(name) + "." + strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+// processAlive reports whether a process with the given PID exists and has
+// not yet terminated. A zombie left unreaped by its new parent counts as dead.
+func processAlive(pid int) bool {
+    err := syscall.Kill(pid, 0)
+    if err != nil && !errors.Is(err, syscall.EPERM) {
+        return false
+    }
+
+    // The state field follows the parenthesised command name in /proc/<pid>/stat.
+    stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+    if err != nil {
+        return true
+    }
+    if i := bytes.LastIndexByte(stat, ')'); i >= 0 && i+2 < len(stat) {
+        return stat[i+2] != 'Z'
+    }
+    return true
+}
+
+// processRunsExecutable reports whether the process with the given PID has
+// path among its arguments, guarding against PID reuse. Where /proc is not
+// available the check cannot be made and it reports true.
+func processRunsExecutable(pid int, path string) bool {
+    cmdline, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+    if err != nil {
+        return !errors.Is(err, os.ErrNotExist)
+    }
+    for _, arg := range bytes.Split(cmdline, []byte{0}) {
+        if string(arg) == path {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "os"
+)
+
+// loadClientCAConfig returns a TLS configuration that requires clients to
+// present a certificate signed by one of the CAs in the PEM file at path.
+func loadClientCAConfig(path string) (*tls.Config, error) {
+    pem, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read client CA file: %w", err)
+    }
+
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("no certificates found in client CA file %s", path)
+    }
+    return &tls.Config{
+        ClientCAs:  pool,
+        ClientAuth: tls.RequireAndVerifyClientCert,
+        MinVersion: tls.VersionTLS12,
+    }, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+    "runtime/debug"
+
+    "gowork/api"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+    version   = "dev"
+    commit    = ""
+    buildDate = ""
+)
+
+// buildInfo returns the version information of this binary. Commit and build
+// date fall back to the VCS details the Go toolchain embeds when they were
+// not set with -ldflags.
+func buildInfo() api.VersionInfo {
+    info := api.VersionInfo{Version: version, Commit: commit, BuildDate: buildDate}
+    if bi, ok := debug.ReadBuildInfo(); ok {
+        info.GoVersion = bi.GoVersion
+        for _, s := range bi.Settings {
+            switch {
+            case s.Key == "vcs.revision" && info.Commit == "":
+                info.Commit = s.Value
+            case s.Key == "vcs.time" && info.BuildDate == "":
+                info.BuildDate = s.Value
+            }
+        }
+    }
+    return info
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "context"
+    "log"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+)
+
+// completionPollInterval is how often WaitForCompletion checks on a process
+// that is waiting to be restarted.
+const completionPollInterval = 100 * time.Millisecond
+
+// WaitForCompletion blocks until the process has stopped for good: it has
+// exited and no automatic restart is pending.
+func (pm *ProcessManager) WaitForCompletion() {
+    for {
+        pm.WaitForExit(context.Background())
+        pm.mu.Lock()
+        pending := pm.aliveLocked() || pm.status == StatusBackoff || pm.status == StatusStarting
+        pm.mu.Unlock()
+        if !pending {
+            return
+        }
+        time.Sleep(completionPollInterval)
+    }
+}
+
+// ExitStatus returns the status the manager exits with in -wait mode: the
+// exit code of the last run, 128 plus the signal number if a signal killed
+// it as in a shell, or 1 if it never ran or could not be waited for.
+func (pm *ProcessManager) ExitStatus() int {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+    switch {
+    case pm.exitSignal != 0:
+        return 128 + int(pm.exitSignal)
+    case pm.exitCode != nil && *pm.exitCode >= 0:
+        return *pm.exitCode
+    default:
+        return 1
+    }
+}
+
+// runToCompletion supervises pm without an HTTP server until it has
+// stopped for good and returns the manager's exit status. SIGHUP is
+// forwarded; SIGINT and SIGTERM stop the process, whose exit status is
+// then returned as usual.
+func runToCompletion(pm *ProcessManager, stopTimeout time.Duration) int {
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+    defer signal.Stop(signals)
+
+    finished := make(chan struct{})
+    go func() {
+        pm.WaitForCompletion()
+        close(finished)
+    }()
+
+    for {
+        select {
+        case <-finished:
+            status := pm.ExitStatus()
+            log.Printf("Process %q finished; exiting with status %d.", pm.name, status)
+            return status
+        case sig := <-signals:
+            s := sig.(syscall.Signal)
+            if s == syscall.SIGHUP {
+                if err := pm.Signal(s); err != nil {
+                    log.Printf("Failed to forward %s: %v", signalName(s), err)
+                }
+                continue
+            }
+            log.Printf("Received %s, stopping %q.", signalName(s), pm.name)
+            if err := pm.StopWithSignal(s, stopTimeout); err != nil {
+                log.Printf("Failed to stop %q: %v", pm.name, err)
+            }
+        }
+    }
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+    "context"
+    "io/fs"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// fileWatcher calls onChange when any of its paths is created, modified or
+// removed. Directories are watched recursively, apart from hidden ones such
+// as .git below them. onChange only runs once no further change has been
+// seen for the debounce period, so a build writing many files restarts only
+// once.
+type fileWatcher struct {
+    watcher  *fsnotify.Watcher
+    dirs     []string        // Directories watched along with everything below them.
+    files    map[string]bool // Files watched through their parent directory.
+    debounce time.Duration
+    onChange func()
+}
+
+// newFileWatcher returns a watcher of paths, each of which must exist.
+func newFileWatcher(paths []string, debounce time.Duration, onChange func()) (*fileWatcher, error) {
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        return nil, err
+    }
+    w := &fileWatcher{watcher: watcher, files: make(map[string]bool), debounce: debounce, onChange: onChange}
+    for _, path := range paths {
+        path = filepath.Clean(path)
+        info, err := os.Stat(path)
+        if err == nil {
+            if info.IsDir() {
+                w.dirs = append(w.dirs, path)
+                err = w.addTree(path)
+            } else {
+                // Editors often save by replacing the file, which would end
+                // a watch on the file itself.
+                w.files[path] = true
+                err = watcher.Add(filepath.Dir(path))
+            }
+        }
+        if err != nil {
+            watcher.Close()
+            return nil, err
+        }
+    }
+    return w, nil
+}
+
+// addTree watches root and the directories below it, skipping hidden ones.
+// Only failing to watch root itself is an error.
+func (w *fileWatcher) addTree(root string) error {
+    if err := w.watcher.Add(root); err != nil {
+        return err
+    }
+    filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil || !d.IsDir() || path == root {
+            return nil
+        }
+        if hiddenName(d.Name()) {
+            return filepath.SkipDir
+        }
+        if err := w.watcher.Add(path); err != nil {
+            log.Printf("Failed to watch %s: %v", path, err)
+        }
+        return nil
+    })
+    return nil
+}
+
+// run handles file system events until the process exits.
+func (w *fileWatcher) run() {
+    debounce := time.NewTimer(w.debounce)
+    debounce.Stop()
+    for {
+        select {
+        case ev := <-w.watcher.Events:
+            if !w.relevant(ev.Name) {
+                continue
+            }
+            if ev.Has(fsnotify.Create) {
+                if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+                    if hiddenName(info.Name()) {
+                        continue
+                    }
+                    // Files written into it before it is watched are
+                    // covered by the restart this event triggers anyway.
+                    if err := w.addTree(ev.Name); err != nil {
+                        log.Printf("Failed to watch %s: %v", ev.Name, err)
+                    }
+                }
+            }
+            debounce.Reset(w.debounce)
+        case err := <-w.watcher.Errors:
+            log.Printf("File watcher error: %v", err)
+        case <-debounce.C:
+            w.onChange()
+        }
+    }
+}
+
+// relevant reports whether a change to path concerns a watched file, or a
+// file below a watched directory that is not inside a hidden one.
+func (w *fileWatcher) relevant(path string) bool {
+    if w.files[path] {
+        return true
+    }
+    for _, dir := range w.dirs {
+        rel, err := filepath.Rel(dir, path)
+        if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+            continue
+        }
+        parts := strings.Split(rel, string(filepath.Separator))
+        hidden := false
+        for _, part := range parts[:len(parts)-1] {
+            hidden = hidden || hiddenName(part)
+        }
+        if !hidden {
+            return true
+        }
+    }
+    return false
+}
+
+// hiddenName reports whether a file or directory name is hidden.
+func hiddenName(name string) bool {
+    return len(name) > 1 && name[0] == '.' && name != ".."
+}
+
+// restartOnChange restarts every process that was not stopped on purpose;
+// processes that are stopped or were never started are left alone.
+func restartOnChange(reg *Registry, stopTimeout time.Duration) func() {
+    return func() {
+        reg.Each(func(name string, pm *ProcessManager) {
+            switch pm.GetStatus() {
+            case StatusStopped, StatusNotStarted:
+                return
+            }
+            log.Printf("Watched files changed, restarting %q.", name)
+            if err := pm.Restart(context.Background(), stopTimeout); err != nil {
+                log.Printf("Failed to restart %q after a change: %v", name, err)
+            }
+        })
+    }
+}
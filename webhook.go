@@ -0,0 +1,133 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+
+    "gowork/api"
+)
+
+const (
+    // webhookQueueSize bounds the transitions waiting for delivery; further
+    // ones are dropped rather than blocking the process they come from.
+    webhookQueueSize = 100
+    // webhookAttempts is how often delivery of a transition is tried.
+    webhookAttempts = 3
+    // webhookBackoff is the delay before the first retry, doubling after each.
+    webhookBackoff = time.Second
+    // webhookTimeout bounds a single delivery attempt.
+    webhookTimeout = 5 * time.Second
+)
+
+// StatusTransition is the payload posted to the webhook.
+type StatusTransition = api.StatusTransition
+
+// webhookNotifier posts status transitions to a URL from a goroutine of its
+// own, in the order they happened, so transitions recorded under a
+// manager's lock never wait for the network. Without a URL transitions
+// are discarded.
+type webhookNotifier struct {
+    mu     sync.Mutex
+    url    string
+    client *http.Client
+    queue  chan StatusTransition
+}
+
+// newWebhookNotifier starts delivering transitions to url, which may be
+// empty until set with SetURL.
+func newWebhookNotifier(url string) *webhookNotifier {
+    n := &webhookNotifier{
+        url:    url,
+        client: &http.Client{Timeout: webhookTimeout},
+        queue:  make(chan StatusTransition, webhookQueueSize),
+    }
+    go n.run()
+    return n
+}
+
+// SetURL changes where transitions are delivered from now on; an empty url
+// stops delivery.
+func (n *webhookNotifier) SetURL(url string) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    n.url = url
+}
+
+func (n *webhookNotifier) getURL() string {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    return n.url
+}
+
+// Notify queues t for delivery without blocking.
+func (n *webhookNotifier) Notify(t StatusTransition) {
+    if n.getURL() == "" {
+        return
+    }
+    select {
+    case n.queue <- t:
+    default:
+        log.Printf("Webhook queue full, dropping %s transition of %q.", t.NewStatus, t.Process)
+    }
+}
+
+func (n *webhookNotifier) run() {
+    for t := range n.queue {
+        body, err := json.Marshal(t)
+        if err != nil {
+            continue
+        }
+        delay := webhookBackoff
+        for attempt := 1; ; attempt++ {
+            url := n.getURL()
+            if url == "" {
+                break
+            }
+            err := n.post(url, body)
+            if err == nil {
+                break
+            }
+            if attempt == webhookAttempts {
+                log.Printf("Webhook delivery of %s transition of %q failed, giving up: %v", t.NewStatus, t.Process, err)
+                break
+            }
+            log.Printf("Webhook delivery failed (attempt %d/%d), retrying in %v: %v", attempt, webhookAttempts, delay, err)
+            time.Sleep(delay)
+            delay *= 2
+        }
+    }
+}
+
+// post sends one delivery attempt, failing on any non-2xx answer.
+func (n *webhookNotifier) post(url string, body []byte) error {
+    resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("webhook answered %s", resp.Status)
+    }
+    return nil
+}
+
+// notifyTransitionLocked queues a webhook notification if the status has
+// changed since the last one. The caller must hold pm.mu.
+func (pm *ProcessManager) notifyTransitionLocked() {
+    if pm.webhook == nil || pm.status == pm.notifiedStatus {
+        return
+    }
+    pm.webhook.Notify(StatusTransition{
+        Process:   pm.name,
+        OldStatus: pm.notifiedStatus,
+        NewStatus: pm.status,
+        ExitCode:  pm.exitCode,
+        Timestamp: time.Now(),
+    })
+    pm.notifiedStatus = pm.status
+}
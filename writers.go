@@ -0,0 +1,92 @@
+package main
+
+import (
+    "bytes"
+    "io"
+    "log"
+)
+
+// bestEffortWriter passes writes on to w but always reports success. The
+// output of a child is copied through an io.MultiWriter, which stops at the
+// first failing writer; a full disk under the log file or a closed console
+// would then stop the copy, leaving the child blocked on a full pipe and
+// the in-memory logs without its output. A failure is logged once, along
+// with the recovery once writes succeed again.
+type bestEffortWriter struct {
+    name   string // Describes w in log messages, such as "log file".
+    w      io.Writer
+    failed bool
+}
+
+func (b *bestEffortWriter) Write(p []byte) (int, error) {
+    _, err := b.w.Write(p)
+    switch {
+    case err != nil && !b.failed:
+        b.failed = true
+        log.Printf("Writing process output to the %s failed, dropping it until writes succeed: %v", b.name, err)
+    case err == nil && b.failed:
+        b.failed = false
+        log.Printf("Writing process output to the %s succeeded again.", b.name)
+    }
+    return len(p), nil
+}
+
+// truncatedLineMarker ends a line of output cut short by a lineLimitWriter.
+const truncatedLineMarker = " [line truncated]"
+
+// lineLimitWriter passes output on to w, cutting every line longer than max
+// bytes short with truncatedLineMarker and dropping the rest of it up to the
+// line ending. A child writing one endless line then cannot make the writers
+// that collect whole lines grow without bound.
+type lineLimitWriter struct {
+    w   io.Writer
+    max int
+    n   int  // Bytes of the current line passed on so far.
+    cut bool // The current line has been cut short.
+    buf []byte
+}
+
+// limitLines returns w limited to lines of max bytes, or w itself if max is
+// not positive.
+func limitLines(w io.Writer, max int) io.Writer {
+    if max <= 0 {
+        return w
+    }
+    return &lineLimitWriter{w: w, max: max}
+}
+
+func (l *lineLimitWriter) Write(p []byte) (int, error) {
+    n := len(p)
+    l.buf = l.buf[:0]
+    for len(p) > 0 {
+        line := p
+        end := bytes.IndexByte(p, '\n')
+        if end >= 0 {
+            line = p[:end]
+        }
+        if !l.cut {
+            if room := l.max - l.n; len(line) > room {
+                l.buf = append(l.buf, line[:room]...)
+                l.buf = append(l.buf, truncatedLineMarker...)
+                l.cut = true
+            } else {
+                l.buf = append(l.buf, line...)
+                l.n += len(line)
+            }
+        }
+        if end < 0 {
+            break
+        }
+        l.buf = append(l.buf, '\n')
+        l.n = 0
+        l.cut = false
+        p = p[end+1:]
+    }
+    if len(l.buf) == 0 {
+        return n, nil
+    }
+    if _, err := l.w.Write(l.buf); err != nil {
+        return 0, err
+    }
+    return n, nil
+}
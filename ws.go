@@ -0,0 +1,137 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// wsMessage is the envelope of every WebSocket message. Clients send
+// {"type":"command","action":"start"}; the server sends log lines, status
+// updates, command results and errors.
+type wsMessage struct {
+    Type   string        `json:"type"`
+    Action string        `json:"action,omitempty"`
+    Line   string        `json:"line,omitempty"`
+    Status ProcessStatus `json:"status,omitempty"`
+    Error  string        `json:"error,omitempty"`
+}
+
+// wsStatusInterval is how often a client's status is checked for changes.
+const wsStatusInterval = time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// wsConn serializes writes to a WebSocket connection, which gorilla/websocket
+// allows only from one goroutine at a time.
+type wsConn struct {
+    mu   sync.Mutex
+    conn *websocket.Conn
+}
+
+func (c *wsConn) send(msg wsMessage) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.conn.WriteJSON(msg)
+}
+
+// makeWebSocketHandler streams log lines and status changes to the client
+// and runs the start/stop/restart/kill commands it sends back. Every client
+// gets its own log subscription. Each command takes a token from bucket,
+// the limit shared with the control endpoints, unless bucket is nil.
+func makeWebSocketHandler(pm *ProcessManager, stopTimeout time.Duration, bucket *tokenBucket) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        conn, err := upgrader.Upgrade(w, r, nil)
+        if err != nil {
+            log.Printf("API: /ws upgrade failed: %v", err)
+            return
+        }
+        defer conn.Close()
+        log.Println("API: /ws client connected.")
+
+        c := &wsConn{conn: conn}
+        ch := pm.SubscribeLogs()
+        defer pm.UnsubscribeLogs(ch)
+
+        // The reader runs commands and signals disconnection by closing closed.
+        closed := make(chan struct{})
+        go func() {
+            defer close(closed)
+            for {
+                var msg wsMessage
+                if err := conn.ReadJSON(&msg); err != nil {
+                    return
+                }
+                if msg.Type != "command" {
+                    c.send(wsMessage{Type: "error", Error: "unsupported message type: " + msg.Type})
+                    continue
+                }
+                if bucket != nil {
+                    if ok, wait := bucket.Allow(); !ok {
+                        log.Printf("API: /ws command %q rejected: control rate limit exceeded", msg.Action)
+                        c.send(wsMessage{Type: "error", Action: msg.Action, Error: fmt.Sprintf("control rate limit exceeded, retry in %v", wait.Round(time.Millisecond))})
+                        continue
+                    }
+                }
+                if err := runWebSocketCommand(r, pm, msg.Action, stopTimeout); err != nil {
+                    c.send(wsMessage{Type: "error", Action: msg.Action, Error: err.Error()})
+                    continue
+                }
+                c.send(wsMessage{Type: "status", Status: pm.GetStatus()})
+            }
+        }()
+
+        status := pm.GetStatus()
+        if err := c.send(wsMessage{Type: "status", Status: status}); err != nil {
+            return
+        }
+
+        ticker := time.NewTicker(wsStatusInterval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-closed:
+                log.Println("API: /ws client disconnected.")
+                return
+            case line := <-ch:
+                if err := c.send(wsMessage{Type: "log", Line: line}); err != nil {
+                    return
+                }
+            case <-ticker.C:
+                if current := pm.GetStatus(); current != status {
+                    status = current
+                    if err := c.send(wsMessage{Type: "status", Status: status}); err != nil {
+                        return
+                    }
+                }
+            }
+        }
+    }
+}
+
+// runWebSocketCommand performs a command action received over /ws.
+func runWebSocketCommand(r *http.Request, pm *ProcessManager, action string, stopTimeout time.Duration) error {
+    log.Printf("API: /ws command %q.", action)
+    switch action {
+    case "start":
+        return pm.Start()
+    case "stop":
+        if stopTimeout > 0 {
+            return pm.StopWithTimeout(stopTimeout)
+        }
+        return pm.Stop()
+    case "restart":
+        return pm.Restart(r.Context(), stopTimeout)
+    case "kill":
+        return pm.ForceKill()
+    case "drain":
+        return pm.Drain()
+    default:
+        return fmt.Errorf("unknown action: %s", action)
+    }
+}